@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"tabswitcher/win32"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/sys/windows"
+)
+
+// virtualDesktopsAvailable is detected once at startup
+var virtualDesktopsAvailable bool
+
+// selfElevated is set at startup when the switcher itself runs elevated
+var selfElevated bool
+
+// selfIntegrity is the integrity level of the switcher's own process, set at
+// startup
+var selfIntegrity uint32 = win32.SECURITY_MANDATORY_MEDIUM_RID
+
+// canControlWindow reports whether messages we send to hwnd, such as to
+// close, minimize or move it, are likely to get through. UIPI drops messages
+// to windows of processes with a higher integrity level than ours. A process
+// whose token we can't even read is assumed to be elevated, which is the
+// usual reason for that.
+func canControlWindow(hwnd windows.HWND) bool {
+	proc := getWindowProcess(hwnd)
+	if !proc.integrityKnown {
+		return selfIntegrity >= win32.SECURITY_MANDATORY_HIGH_RID
+	}
+	return proc.integrity <= selfIntegrity
+}
+
+// canActivate guesses whether activating window will work, so the frontend
+// can grey out windows that likely won't come to the front. There's no way to
+// know for sure without trying, so this only looks at the two usual causes:
+//
+//   - The window belongs to an elevated process while we aren't elevated.
+//     UIPI then blocks our input and messages to it, and focus changes to it
+//     are often refused.
+//   - The window is on another virtual desktop. activateWindow falls back to
+//     SwitchToThisWindow there, which works on most builds but not all.
+//
+// A window whose elevation or desktop couldn't be read counts as activatable.
+func canActivate(window UserWindow, onCurrentDesktop bool) bool {
+	if window.elevated && !selfElevated {
+		return false
+	}
+	return onCurrentDesktop
+}
+
+// sessionStart tells LastActive values recorded in this session apart from
+// the ones restored from the previous one
+var sessionStart = time.Now()
+
+// markActive records hwnd as the most recently active window, returning the
+// updated window if it's tracked
+func markActive(hwnd windows.HWND) (UserWindow, bool) {
+	userWindowsMu.Lock()
+	defer userWindowsMu.Unlock()
+	window, ok := FindWindow(hwnd)
+	if ok {
+		window.LastActive = int(time.Now().UnixMilli())
+		userWindows.Store(hwnd, window)
+	}
+	return window, ok
+}
+
+// refreshCaption re-reads hwnd's title into the tracked window. Apps often
+// show a placeholder such as "Loading..." while starting, which the list may
+// still have if the real title came in since the last enumeration.
+func refreshCaption(hwnd windows.HWND) {
+	caption, err := windowProvider.Caption(hwnd)
+	if err != nil || caption == "" {
+		return
+	}
+
+	userWindowsMu.Lock()
+	defer userWindowsMu.Unlock()
+	window, ok := FindWindow(hwnd)
+	if !ok || window.Caption == caption {
+		return
+	}
+	window.Caption = caption
+	window.DisplayTitle = displayTitle(window)
+	userWindows.Store(hwnd, window)
+}
+
+// updateActivity fills in the fields derived from LastActive as of now
+func (w *UserWindow) updateActivity(now time.Time) {
+	if w.LastActive == 0 {
+		w.LastActiveAgoMs = -1
+		w.ActiveThisSession = false
+		return
+	}
+	w.LastActiveAgoMs = max(now.UnixMilli()-int64(w.LastActive), 0)
+	w.ActiveThisSession = int64(w.LastActive) >= sessionStart.UnixMilli()
+}
+
+// restoreMinimized restores hwnd if it's minimized, which SetForegroundWindow
+// doesn't do. This is also what picking a window after Show Desktop (Win+D or
+// the desktop entry) runs into, as that minimizes every window. Only hwnd is
+// restored, the others stay minimized like they do with Alt+Tab.
+func restoreMinimized(hwnd windows.HWND) {
+	if !win32.IsIconic(hwnd) {
+		return
+	}
+
+	// A window that was maximized when it got minimized comes back maximized
+	command := int32(win32.SW_RESTORE)
+	var placement win32.WINDOWPLACEMENT
+	if win32.GetWindowPlacement(hwnd, &placement) == nil &&
+		placement.Flags&win32.WPF_RESTORETOMAXIMIZED != 0 {
+		command = win32.SW_SHOWMAXIMIZED
+	}
+	win32.ShowWindow(hwnd, command)
+}
+
+// errStillBehind is returned by activateWindow when SetForegroundWindow
+// reported success but the window didn't come to the front
+var errStillBehind = errors.New("window didn't come to the foreground")
+
+// isForeground reports whether hwnd, or a window it owns such as a dialog,
+// is the foreground window
+func isForeground(hwnd windows.HWND) bool {
+	foreground := win32.GetForegroundWindow()
+	return foreground == hwnd || foreground != 0 && win32.GetAncestor(foreground, win32.GA_ROOTOWNER) == hwnd
+}
+
+// ensureForeground checks that hwnd actually came to the front after
+// SetForegroundWindow, which can report success while an owner relationship
+// keeps the window behind others. It then tries raising the window in
+// increasingly forceful ways.
+func ensureForeground(hwnd windows.HWND) error {
+	if isForeground(hwnd) {
+		return nil
+	}
+
+	log.Printf("Window %v is still behind after SetForegroundWindow, raising it", hwnd)
+	if err := win32.BringWindowToTop(hwnd); err == nil && isForeground(hwnd) {
+		return nil
+	}
+	err := win32.SetWindowPos(hwnd, win32.HWND_TOP, 0, 0, 0, 0, win32.SWP_NOMOVE|win32.SWP_NOSIZE|win32.SWP_SHOWWINDOW)
+	if err == nil {
+		win32.SetForegroundWindow(hwnd)
+		if isForeground(hwnd) {
+			return nil
+		}
+	}
+	if isConsoleWindow(hwnd) && activateConsole(hwnd) {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", errStillBehind, hwnd)
+}
+
+// setForeground makes hwnd the foreground window and checks that it really
+// came to the front
+func setForeground(hwnd windows.HWND) error {
+	success := win32.SetForegroundWindow(hwnd)
+	if !success && isConsoleWindow(hwnd) {
+		success = activateConsole(hwnd)
+	}
+	if !success {
+		return fmt.Errorf("failed to set window %v to foreground", hwnd)
+	}
+	return ensureForeground(hwnd)
+}
+
+// activateWindow brings hwnd to the foreground and records it as the most
+// recently active window. The switch is done once it succeeds, so the
+// switcher is put away without giving the focus back to the window from
+// before it was shown.
+func activateWindow(app *application.App, hwnd windows.HWND) error {
+	if isDesktopEntry(hwnd) {
+		if err := toggleDesktop(); err != nil {
+			return err
+		}
+		hideSwitcher()
+		return nil
+	}
+
+	// SetForegroundWindow doesn't switch virtual desktops, but the Alt+Tab
+	// activation path does. Switching desktops programmatically otherwise needs
+	// the undocumented IVirtualDesktopManagerInternal, whose interface IDs
+	// change between Windows builds.
+	if virtualDesktopsAvailable {
+		onCurrent, err := win32.IsWindowOnCurrentDesktop(hwnd)
+		if err == nil && !onCurrent {
+			log.Printf("Window %v is on another virtual desktop, switching to it", hwnd)
+			win32.SwitchToThisWindow(hwnd, true)
+		}
+	}
+
+	restoreMinimized(hwnd)
+
+	if err := setForeground(hwnd); err != nil {
+		return err
+	}
+	hideSwitcher()
+
+	if config.CenterCursorOnActivate {
+		if err := centerCursorOn(hwnd); err != nil {
+			log.Printf("Failed to move the cursor to window %v: %v", hwnd, err)
+		}
+	}
+
+	if config.RefreshCaptionOnActivate {
+		refreshCaption(hwnd)
+	}
+	window, ok := markActive(hwnd)
+	if ok {
+		log.Printf("Activated window: %s\n", window.Caption)
+		queueUserWindowsEmit(app)
+	}
+	return nil
+}
+
+// activateProcess activates the most recently active window of a process
+// matching query, a pattern as in config.HiddenProcesses (e.g. "chrome.exe"),
+// and returns it
+func activateProcess(app *application.App, query string) (UserWindow, error) {
+	list, err := GetAltTabWindows()
+	if err != nil {
+		return UserWindow{}, err
+	}
+	sortWindows(list, SortModeMRU)
+	for _, window := range list {
+		if processMatches(window.ExePath, query) {
+			return window, activateWindow(app, window.Hwnd)
+		}
+	}
+	return UserWindow{}, fmt.Errorf("no window of a process matching %q", query)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"tabswitcher/win32"
+)
+
+// appNames caches the names looked up by friendlyName, by AppUserModelID and
+// by exe path. Failed lookups are cached as "" so they aren't retried for
+// every window.
+var appNames = struct {
+	sync.Mutex
+	byAUMID map[string]string
+	byExe   map[string]string
+}{byAUMID: map[string]string{}, byExe: map[string]string{}}
+
+// friendlyName returns the best name for the app window belongs to: the
+// installed app's display name for its AppUserModelID ("Windows Terminal"),
+// then the description in its exe's version info ("Google Chrome"), then the
+// exe's file name.
+func friendlyName(window UserWindow) string {
+	if window.AppUserModelID != "" {
+		if name := cachedName(appNames.byAUMID, window.AppUserModelID, win32.GetAppDisplayName); name != "" {
+			return name
+		}
+	}
+	if window.ExePath != "" {
+		if name := cachedName(appNames.byExe, window.ExePath, win32.GetFileDescription); name != "" {
+			return name
+		}
+	}
+	return appName(window)
+}
+
+// cachedName returns cache[key], looking it up with lookup the first time
+func cachedName(cache map[string]string, key string, lookup func(string) (string, error)) string {
+	appNames.Lock()
+	name, ok := cache[key]
+	appNames.Unlock()
+	if ok {
+		return name
+	}
+
+	name, err := lookup(key)
+	if err != nil {
+		debugf("App name lookup failed for %s: %v", key, err)
+	}
+	appNames.Lock()
+	cache[key] = name
+	appNames.Unlock()
+	return name
+}
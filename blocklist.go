@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// processHidden reports whether exePath matches one of config.HiddenProcesses
+func processHidden(exePath string) bool {
+	for _, pattern := range config.HiddenProcesses {
+		if processMatches(exePath, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// processMatches reports whether exePath matches pattern. Patterns use
+// filepath.Match syntax and are compared case-insensitively. A pattern
+// without a directory (e.g. "helper*.exe") is matched against the file name,
+// anything else against the full path.
+func processMatches(exePath, pattern string) bool {
+	if exePath == "" {
+		return false
+	}
+
+	pattern = strings.ToLower(pattern)
+	subject := strings.ToLower(exePath)
+	if !strings.ContainsAny(pattern, `\/`) {
+		subject = filepath.Base(subject)
+	}
+	ok, _ := filepath.Match(pattern, subject)
+	return ok
+}
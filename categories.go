@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Category is a label and color the user assigns to an app's windows, e.g. to
+// tint them by project
+type Category struct {
+	Label string `json:"label"`
+	// Color is a CSS color, e.g. "#3b82f6"
+	Color string `json:"color"`
+}
+
+// windowCategory returns the category configured for the exe, matched by full
+// path first and then by file name, case-insensitively. Apps without one get
+// config.DefaultCategory.
+func windowCategory(exePath string) Category {
+	if exePath == "" {
+		return config.DefaultCategory
+	}
+	for _, key := range []string{exePath, filepath.Base(exePath)} {
+		for match, category := range config.Categories {
+			if strings.EqualFold(match, key) {
+				return category
+			}
+		}
+	}
+	return config.DefaultCategory
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"tabswitcher/win32"
+)
+
+// WindowSize is a width and height in pixels
+type WindowSize struct {
+	Width  int32 `json:"width"`
+	Height int32 `json:"height"`
+}
+
+// AppAction is a user-defined, jump-list-style task offered for an app
+type AppAction struct {
+	Title   string `json:"title"`
+	Command string `json:"command"`
+	Args    string `json:"args"`
+}
+
+type Config struct {
+	// SwitchMode is either SwitchModeWindows or SwitchModeApps
+	SwitchMode string `json:"switchMode"`
+	// MergeDuplicateWindows lists windows of the same process with the same
+	// title and icon as one entry. Some apps open such windows for what is
+	// one view, but for others they're distinct, so it's off by default.
+	MergeDuplicateWindows bool `json:"mergeDuplicateWindows"`
+	// OffScreenWindows is what happens to windows that lie entirely outside
+	// every monitor, one of the OffScreen* values
+	OffScreenWindows string `json:"offScreenWindows"`
+	// OcclusionFilter lists only the windows that are mostly or entirely
+	// covered by others, when set to one of the OcclusionFilter* values. The
+	// occlusion is approximate, see visibleFraction.
+	OcclusionFilter string `json:"occlusionFilter"`
+	// MaxWindows caps the number of windows sent to the frontend, 0 for no
+	// limit. The foreground and always-on-top windows are sent regardless.
+	MaxWindows int `json:"maxWindows"`
+	// SortMode is one of the SortMode* values
+	SortMode string `json:"sortMode"`
+	// NewWindowDelayMs is how long a new window must exist before it's
+	// listed, SplashWindowDelayMs the same for windows that look like splash
+	// screens (no caption, fixed and small size)
+	NewWindowDelayMs    int `json:"newWindowDelayMs"`
+	SplashWindowDelayMs int `json:"splashWindowDelayMs"`
+	// EmitIntervalMs is the least time between two window lists sent to the
+	// frontend. Changes within it are batched, the last one always gets sent.
+	EmitIntervalMs int `json:"emitIntervalMs"`
+	// MetricsPort enables a debugging endpoint on 127.0.0.1 when non-zero
+	MetricsPort int `json:"metricsPort"`
+	// IconOverrides maps an exe path or file name to a PNG file used as the
+	// icon of its windows instead of the app's own icon
+	IconOverrides map[string]string `json:"iconOverrides"`
+	// IconIndexes maps an exe path or file name to the icon to use from a
+	// multi-icon executable, either a zero-based index or a negated resource ID
+	IconIndexes map[string]int32 `json:"iconIndexes"`
+	// IconSources is the order window icons are looked for in, using the
+	// win32.IconSource* names, e.g. to prefer the small icons for a compact
+	// list. Sources left out aren't tried.
+	IconSources []string `json:"iconSources"`
+	// Categories maps an exe path or file name to the category its windows
+	// are tagged with (UserWindow.Category)
+	Categories map[string]Category `json:"categories"`
+	// DefaultCategory is the category of windows whose app isn't in
+	// Categories. Its empty Label leaves them uncategorized by default.
+	DefaultCategory Category `json:"defaultCategory"`
+	// AppActions maps an app key (see appKey) to the tasks offered for it
+	AppActions map[string][]AppAction `json:"appActions"`
+	// Hotkeys are the chords listened for (see InputMode), each sending its
+	// action name to the frontend
+	Hotkeys []Hotkey `json:"hotkeys"`
+	// SuppressWinHotkeys swallows the keys of Hotkeys using Win, so e.g.
+	// Win+Tab opens only the switcher and not Task View as well. This takes
+	// the shortcut away from Windows, so it's off by default. Other hotkeys
+	// always reach the focused app too. Only applies to InputModeHook, the
+	// registered hotkeys of InputModeHotkey are always swallowed.
+	SuppressWinHotkeys bool `json:"suppressWinHotkeys"`
+	// SuppressOverFullscreen ignores Hotkeys while a full-screen app is in the
+	// foreground, so the switcher doesn't pop up over games and videos
+	SuppressOverFullscreen bool `json:"suppressOverFullscreen"`
+	// InputMode is how Hotkeys are picked up, InputModeHook or
+	// InputModeHotkey. See those for the trade-offs.
+	InputMode string `json:"inputMode"`
+	// ShowDesktopEntry adds a "Desktop" entry at the end of the list that
+	// minimizes all windows, or restores them when activated again
+	ShowDesktopEntry bool `json:"showDesktopEntry"`
+	// CenterCursorOnActivate moves the mouse cursor to the center of a window
+	// when it's activated, which helps keep track of it across monitors
+	CenterCursorOnActivate bool `json:"centerCursorOnActivate"`
+	// RefreshCaptionOnActivate re-reads a window's title when it's activated,
+	// so one still listed with a placeholder title gets its real one
+	RefreshCaptionOnActivate bool `json:"refreshCaptionOnActivate"`
+	// UIAutomationFallback asks UI Automation for the name of windows without
+	// a title before falling back to their app's name. It's much slower than
+	// the Win32 title lookup, so it's off by default.
+	UIAutomationFallback bool `json:"uiAutomationFallback"`
+	// MinWindowSize hides windows narrower or shorter than this, which are
+	// usually helper or overlay windows rather than anything to switch to
+	MinWindowSize WindowSize `json:"minWindowSize"`
+	// HiddenProcesses hides every window of the executables matching these
+	// patterns, by file name or full path, wildcards allowed
+	HiddenProcesses []string `json:"hiddenProcesses"`
+	// SwitcherPosition is where the switcher is shown, one of the Position*
+	// values
+	SwitcherPosition string `json:"switcherPosition"`
+	// UpdateMode is how window changes are picked up, one of the UpdateMode*
+	// values. Polling is the most compatible, the WinEvent hooks notice
+	// changes sooner but can misbehave on some locked-down systems.
+	UpdateMode string `json:"updateMode"`
+	// EnumerationBackend is the API windows are listed with, one of the
+	// EnumBackend* values. With debugLogging set, the windows only one of
+	// them finds are logged at startup.
+	EnumerationBackend string `json:"enumerationBackend"`
+	// ShowCommandLines reads the command line of each window's process, which
+	// helps tell apart several windows of the same app (e.g. two VS Code
+	// workspaces). It needs to read other processes' memory on older Windows.
+	ShowCommandLines bool `json:"showCommandLines"`
+	// LazyIcons leaves the icons out of the window list, and the frontend
+	// fetches them with SwitcherService.WindowIconDataURL as entries come into
+	// view. Getting the icons is most of the work of listing new windows.
+	LazyIcons bool `json:"lazyIcons"`
+	// TitleRules maps an app key (see appKey) to the rules that turn its
+	// window titles into UserWindow.DisplayTitle. Entries in the config file
+	// replace the defaults for the same app.
+	TitleRules map[string][]TitleRule `json:"titleRules"`
+	// DebugLogging also logs the lookups that fail routinely, such as icons
+	// or process details that can't be read, for tracking down why a window
+	// is listed wrong
+	DebugLogging bool `json:"debugLogging"`
+}
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		SwitchMode: SwitchModeWindows,
+		SortMode:   SortModeMRU,
+
+		SwitcherPosition: PositionActiveMonitor,
+		UpdateMode:       UpdateModePoll,
+		InputMode:        InputModeHook,
+		OffScreenWindows: OffScreenMove,
+
+		EnumerationBackend:       EnumBackendDesktopWindows,
+		RefreshCaptionOnActivate: true,
+
+		NewWindowDelayMs:    500,
+		SplashWindowDelayMs: 5000,
+		EmitIntervalMs:      100,
+		MinWindowSize:       WindowSize{Width: 48, Height: 48},
+		AppActions:          map[string][]AppAction{},
+		IconSources:         slices.Clone(win32.DefaultIconSources),
+		TitleRules:          maps.Clone(defaultTitleRules),
+		Hotkeys: []Hotkey{
+			{Keys: "Alt+Tab", Action: "tab"},
+			{Keys: "Alt+`", Action: "tilde"},
+		},
+	}
+}
+
+// dataFilePath returns the path of a file in the app's settings directory
+func dataFilePath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "TabSwitcher", name), nil
+}
+
+func configPath() (string, error) {
+	return dataFilePath("config.json")
+}
+
+// loadConfig reads the user's config file on top of the defaults. A missing
+// file is not an error, the defaults are used as-is.
+func loadConfig() Config {
+	cfg := defaultConfig()
+
+	path, err := configPath()
+	if err != nil {
+		log.Printf("Failed to locate config directory: %v", err)
+		return cfg
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg
+	}
+	if err != nil {
+		log.Printf("Failed to read config %s: %v", path, err)
+		return cfg
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Printf("Failed to parse config %s: %v", path, err)
+		return defaultConfig()
+	}
+	log.Printf("Loaded config from %s", path)
+	return cfg
+}
+
+// debugf logs like log.Printf when config.DebugLogging is set
+func debugf(format string, v ...any) {
+	if config.DebugLogging {
+		log.Printf(format, v...)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// conhostClass is the class of classic console windows, drawn by
+	// conhost.exe on behalf of the program running in them
+	conhostClass = "ConsoleWindowClass"
+	// terminalClass is the class of Windows Terminal's windows
+	terminalClass = "CASCADIA_HOSTING_WINDOW_CLASS"
+)
+
+// isConsoleWindow reports whether hwnd is a conhost or Windows Terminal
+// window
+func isConsoleWindow(hwnd windows.HWND) bool {
+	class, err := windowProvider.ClassName(hwnd)
+	return err == nil && (class == conhostClass || class == terminalClass)
+}
+
+// iconSourcesFor returns the icon sources to try for hwnd. A conhost window
+// is reported as belonging to the program running in it (cmd.exe,
+// powershell.exe, ...), but answers WM_GETICON with the icon conhost was
+// started with, which is the generic console icon or whatever program opened
+// the console first. The executable's icon is the one of the program actually
+// shown, so it goes first. Windows Terminal's own icons are right as they are.
+func iconSourcesFor(hwnd windows.HWND) []string {
+	sources := config.IconSources
+	if len(sources) == 0 {
+		sources = win32.DefaultIconSources
+	}
+	class, err := windowProvider.ClassName(hwnd)
+	if err != nil || class != conhostClass {
+		return sources
+	}
+
+	preferred := []string{win32.IconSourceExe}
+	for _, source := range sources {
+		if source != win32.IconSourceExe {
+			preferred = append(preferred, source)
+		}
+	}
+	return slices.Clip(preferred)
+}
+
+// activateConsole is the fallback for console windows SetForegroundWindow
+// refused. conhost windows belong to a process that isn't the one drawing
+// them, which trips up the foreground lock checks, and Windows Terminal only
+// takes focus reliably through the same path Alt+Tab uses.
+func activateConsole(hwnd windows.HWND) bool {
+	win32.SwitchToThisWindow(hwnd, true)
+	return isForeground(hwnd)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"tabswitcher/win32"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/sys/windows"
+)
+
+// windowUnderCursor returns the tracked window under the mouse cursor.
+// WindowFromPoint usually lands on a child control, so the hit is walked up to
+// its top-level window first. Windows that aren't Alt-Tab eligible (the
+// taskbar, the desktop, tool windows) are reported as not found.
+func windowUnderCursor() (UserWindow, bool) {
+	var pt win32.POINT
+	if err := win32.GetCursorPos(&pt); err != nil {
+		return UserWindow{}, false
+	}
+
+	hwnd := win32.WindowFromPoint(pt)
+	if hwnd == 0 {
+		return UserWindow{}, false
+	}
+	root := win32.GetAncestor(hwnd, win32.GA_ROOT)
+	if root == 0 || !win32.IsAltTabWindow(root) {
+		return UserWindow{}, false
+	}
+
+	return FindWindow(root)
+}
+
+// activateWindowUnderCursor switches to the window under the mouse cursor
+func activateWindowUnderCursor(app *application.App) (UserWindow, error) {
+	window, ok := windowUnderCursor()
+	if !ok {
+		return UserWindow{}, fmt.Errorf("no switchable window under the cursor")
+	}
+	return window, activateWindow(app, window.Hwnd)
+}
+
+// centerCursorOn moves the mouse cursor to the center of the part of hwnd
+// that's on its monitor, so it doesn't end up off-screen for windows hanging
+// over a monitor edge
+func centerCursorOn(hwnd windows.HWND) error {
+	bounds, err := windowProvider.Bounds(hwnd)
+	if err != nil {
+		return err
+	}
+
+	monitor := win32.MonitorFromWindow(hwnd, win32.MONITOR_DEFAULTTONEAREST)
+	var info win32.MONITORINFO
+	if err := win32.GetMonitorInfoW(monitor, &info); err != nil {
+		return fmt.Errorf("GetMonitorInfoW failed: %w", err)
+	}
+	visible, ok := win32.IntersectRects(bounds, info.RcMonitor)
+	if !ok {
+		visible = info.RcMonitor
+	}
+
+	x := visible.Left + (visible.Right-visible.Left)/2
+	y := visible.Top + (visible.Bottom-visible.Top)/2
+	if err := win32.SetCursorPos(x, y); err != nil {
+		return fmt.Errorf("SetCursorPos failed: %w", err)
+	}
+	return nil
+}
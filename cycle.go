@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"tabswitcher/win32"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/sys/windows"
+)
+
+// cycleResetDelay is how long a same-app cycle is remembered between presses.
+// Within it, repeated presses walk the original Z-order instead of bouncing
+// between the two most recent windows.
+const cycleResetDelay = 1500 * time.Millisecond
+
+type processCycle struct {
+	mu       sync.Mutex
+	process  processKey
+	order    []windows.HWND
+	position int
+	lastStep time.Time
+}
+
+var sameAppCycle processCycle
+
+// processWindows returns the Alt-Tab windows of one process in Z-order
+func processWindows(process processKey) ([]UserWindow, error) {
+	windows, err := GetAltTabWindows()
+	var result []UserWindow
+	for _, window := range windows {
+		if window.process() == process {
+			result = append(result, window)
+		}
+	}
+	return result, err
+}
+
+// step returns the next window of process to activate, continuing the
+// previous cycle if it was for the same process and recent enough
+func (c *processCycle) step(process processKey, candidates []UserWindow) (windows.HWND, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	if c.process != process || time.Since(c.lastStep) > cycleResetDelay {
+		c.process = process
+		c.order = c.order[:0]
+		for _, window := range candidates {
+			c.order = append(c.order, window.Hwnd)
+		}
+		// Index 0 is the window we're cycling away from
+		c.position = 0
+	}
+	c.lastStep = time.Now()
+
+	// Skip windows that were closed since the cycle started
+	for range c.order {
+		c.position = (c.position + 1) % len(c.order)
+		hwnd := c.order[c.position]
+		for _, window := range candidates {
+			if window.Hwnd == hwnd {
+				return hwnd, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// cycleWithinProcess activates the next window of pid and returns all of the
+// process's windows in Z-order. A pid of 0 means the foreground window's process.
+func cycleWithinProcess(app *application.App, pid uint32) ([]UserWindow, error) {
+	if pid == 0 {
+		var processId win32.DWORD
+		win32.GetWindowThreadProcessId(win32.GetForegroundWindow(), &processId)
+		pid = uint32(processId)
+	}
+
+	process := processKeyOf(pid)
+	windows, err := processWindows(process)
+	if err != nil {
+		return windows, err
+	}
+
+	hwnd, ok := sameAppCycle.step(process, windows)
+	if !ok {
+		return windows, fmt.Errorf("process %d has no windows to cycle through", pid)
+	}
+	return windows, activateWindow(app, hwnd)
+}
+
+// activateSiblingWindow activates the window of the foreground window's
+// process that comes after it (direction > 0) or before it (direction < 0),
+// wrapping around at the ends. The windows are taken in the order they were
+// first seen rather than Z-order, which every activation reshuffles, so
+// repeated calls walk all of them without any state kept in between.
+func activateSiblingWindow(app *application.App, direction int) (UserWindow, error) {
+	foreground := win32.GetForegroundWindow()
+	var processId win32.DWORD
+	win32.GetWindowThreadProcessId(foreground, &processId)
+
+	siblings, err := processWindows(processKeyOf(uint32(processId)))
+	if err != nil {
+		return UserWindow{}, err
+	}
+	if len(siblings) == 0 {
+		return UserWindow{}, fmt.Errorf("the foreground window's process has no windows to switch to")
+	}
+	slices.SortFunc(siblings, func(a, b UserWindow) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	// A foreground window that isn't listed itself (e.g. a dialog) starts
+	// from the first or last sibling
+	current := slices.IndexFunc(siblings, func(window UserWindow) bool {
+		return window.Hwnd == foreground
+	})
+	var next int
+	switch {
+	case current < 0 && direction < 0:
+		next = len(siblings) - 1
+	case current < 0:
+		next = 0
+	case direction < 0:
+		next = (current - 1 + len(siblings)) % len(siblings)
+	default:
+		next = (current + 1) % len(siblings)
+	}
+
+	window := siblings[next]
+	return window, activateWindow(app, window.Hwnd)
+}
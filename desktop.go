@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync/atomic"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+// desktopEntryID is the UserWindow.ID of the "Show Desktop" entry. Real
+// windows get their IDs counting up from 1, so this never collides.
+const desktopEntryID = math.MaxUint64
+
+// desktopShown is set while the windows are minimized by the desktop entry,
+// so activating it again restores them
+var desktopShown atomic.Bool
+
+// desktopEntry is the synthetic entry appended to the list when
+// config.ShowDesktopEntry is set. Its Hwnd is the shell's desktop window.
+func desktopEntry() UserWindow {
+	shell := win32.GetShellWindow()
+	return UserWindow{
+		ID:           desktopEntryID,
+		Hwnd:         shell,
+		Caption:      "Desktop",
+		DisplayTitle: "Desktop",
+		IconSource:   "desktop",
+		IsDesktop:    true,
+		CanActivate:  true,
+		Dpi:          win32.GetWindowDpi(shell),
+
+		LastActiveAgoMs: -1,
+	}
+}
+
+// isDesktopEntry reports whether hwnd refers to the desktop entry
+func isDesktopEntry(hwnd windows.HWND) bool {
+	return config.ShowDesktopEntry && hwnd != 0 && hwnd == win32.GetShellWindow()
+}
+
+// toggleDesktop minimizes all windows, or restores them if the previous
+// toggle minimized them, the same as Win+M and Win+Shift+M
+func toggleDesktop() error {
+	className, err := windows.UTF16PtrFromString("Shell_TrayWnd")
+	if err != nil {
+		return err
+	}
+	tray := win32.FindWindowW(className, nil)
+	if tray == 0 {
+		return errors.New("taskbar window not found")
+	}
+
+	command := win32.MIN_ALL
+	if desktopShown.Load() {
+		command = win32.MIN_ALL_UNDO
+	}
+	win32.SendMessage(tray, win32.WM_COMMAND, win32.WPARAM(command), 0)
+	desktopShown.Store(command == win32.MIN_ALL)
+
+	log.Printf("Toggled desktop, shown: %v", desktopShown.Load())
+	return nil
+}
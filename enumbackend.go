@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// EnumBackendDesktopWindows lists windows with EnumDesktopWindows on the
+	// current desktop
+	EnumBackendDesktopWindows = "enumDesktopWindows"
+	// EnumBackendWindows lists windows with EnumWindows instead. Whether it
+	// finds windows the other misses in remote and multi-session setups
+	// hasn't been checked yet, so EnumBackendDesktopWindows stays the default
+	// until compareEnumerationBackends has turned up data either way.
+	EnumBackendWindows = "enumWindows"
+)
+
+// providerFor returns the window provider for one of the EnumBackend* values.
+// Unknown backends fall back to EnumBackendDesktopWindows.
+func providerFor(backend string) win32.WindowProvider {
+	if backend == EnumBackendWindows {
+		return win32.SystemTopLevelWindows
+	}
+	return win32.SystemWindows
+}
+
+// compareEnumerationBackends enumerates with both backends and logs the
+// windows only one of them found, to help pick config.EnumerationBackend
+func compareEnumerationBackends() {
+	desktop, err := win32.SystemWindows.EnumWindows()
+	if err != nil {
+		log.Printf("Failed to enumerate with %s: %v", EnumBackendDesktopWindows, err)
+		return
+	}
+	topLevel, err := win32.SystemTopLevelWindows.EnumWindows()
+	if err != nil {
+		log.Printf("Failed to enumerate with %s: %v", EnumBackendWindows, err)
+		return
+	}
+
+	onlyDesktop := missingFrom(desktop, topLevel)
+	onlyTopLevel := missingFrom(topLevel, desktop)
+	log.Printf("%s found %d windows (%d only there), %s found %d (%d only there)",
+		EnumBackendDesktopWindows, len(desktop), len(onlyDesktop),
+		EnumBackendWindows, len(topLevel), len(onlyTopLevel))
+	for _, hwnd := range onlyDesktop {
+		log.Printf("  only in %s: %v", EnumBackendDesktopWindows, hwnd)
+	}
+	for _, hwnd := range onlyTopLevel {
+		log.Printf("  only in %s: %v", EnumBackendWindows, hwnd)
+	}
+}
+
+// missingFrom returns the handles in hwnds that aren't in other
+func missingFrom(hwnds, other []windows.HWND) []windows.HWND {
+	present := make(map[windows.HWND]bool, len(other))
+	for _, hwnd := range other {
+		present[hwnd] = true
+	}
+	var missing []windows.HWND
+	for _, hwnd := range hwnds {
+		if !present[hwnd] {
+			missing = append(missing, hwnd)
+		}
+	}
+	return missing
+}
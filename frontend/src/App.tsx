@@ -1,8 +1,44 @@
 import { Events, Window } from "@wailsio/runtime";
 import { useEffect, useRef, useState } from "react";
-import { UserWindow } from "../bindings/tabswitcher";
+import { SwitcherService, UserWindow } from "../bindings/tabswitcher";
 import { clsx } from "./utils";
 
+// Icons fetched for windows listed without one (the lazyIcons option), by
+// window ID. IDs are never reused, so entries never go stale.
+const fetchedIcons = new Map<number, string>();
+
+function WindowIcon({ window }: { window: UserWindow }) {
+  const [src, setSrc] = useState(window.IconBase64 || fetchedIcons.get(window.ID) || "");
+
+  useEffect(() => {
+    const known = window.IconBase64 || fetchedIcons.get(window.ID);
+    if (known || window.IsDesktop) {
+      setSrc(known ?? "");
+      return;
+    }
+
+    let cancelled = false;
+    SwitcherService.WindowIconDataURL(window.ID)
+      .then((url) => {
+        fetchedIcons.set(window.ID, url);
+        if (!cancelled) {
+          setSrc(url);
+        }
+      })
+      // Windows without a usable icon keep the placeholder
+      .catch(() => {});
+    return () => {
+      cancelled = true;
+    };
+  }, [window.ID, window.IconBase64, window.IsDesktop]);
+
+  return src ? (
+    <img src={src} alt="icon" className="size-10 max-w-max" />
+  ) : (
+    <div className="size-10 rounded-md bg-gray-300" />
+  );
+}
+
 function App() {
   const [windowsState, setWindowsState] = useState<{
     windows: UserWindow[];
@@ -11,6 +47,7 @@ function App() {
     windows: [],
     selectedWindow: null,
   });
+  const [enumerationError, setEnumerationError] = useState<string | null>(null);
   const iconsContainer = useRef<HTMLDivElement>(null);
 
   function activateWindow(hwnd: UserWindow["Hwnd"]) {
@@ -25,8 +62,9 @@ function App() {
     const body = document.body;
 
     const unregisterWindowsChanged = Events.On("userWindowsChanged", (event) => {
+      // Already ordered by the configured sort mode
       const windows = (event.data ?? []) as UserWindow[];
-      windows.sort((a, b) => b.LastActive - a.LastActive);
+      setEnumerationError(null);
 
       setWindowsState((prevState) => {
         const { selectedWindow } = prevState;
@@ -54,6 +92,11 @@ function App() {
       });
     });
 
+    // Sent right after userWindowsChanged when the list is a stale one
+    const unregisterEnumerationFailed = Events.On("windowEnumerationFailed", (event) => {
+      setEnumerationError(event.data as string);
+    });
+
     const unregisterSystemKeyPressed = Events.On("systemKeyPressed", (event) => {
       if (event.data === "tab") {
         setWindowsState((prevState) => {
@@ -93,24 +136,35 @@ function App() {
     return () => {
       clearInterval(resizeInterval);
       unregisterWindowsChanged();
+      unregisterEnumerationFailed();
       unregisterSystemKeyPressed();
     };
   }, []);
 
   return (
     <div ref={iconsContainer} className="absolute left-0 top-0 flex gap-1 p-3">
+      {enumerationError && (
+        <div className="w-[7rem] self-center text-center text-xs text-red-600">
+          Window list may be out of date: {enumerationError}
+        </div>
+      )}
+      {windowsState.windows.every((window) => window.IsDesktop) && !enumerationError && (
+        <div className="w-[7rem] self-center text-center text-xs">No windows to switch to</div>
+      )}
       {windowsState.windows.map((window) => (
         <div
-          key={window.Hwnd}
+          key={window.ID}
           className={clsx(
             "flex w-[7rem] cursor-pointer flex-col items-center gap-1 rounded-lg p-2 hover:bg-gray-200",
             window.Hwnd === windowsState.selectedWindow && "!bg-gray-100"
           )}
           onClick={() => activateWindow(window.Hwnd)}
         >
-          <img src={window.IconBase64} alt="icon" className="size-10 max-w-max" />
-          <span className="text-xs">{window.IconSource}</span>
-          <div className="w-full truncate text-center text-xs">{window.Caption}</div>
+          <WindowIcon window={window} />
+          <span className={clsx("text-xs", window.IsDesktop && "italic")}>
+            {window.IsDesktop ? "Show Desktop" : window.IconSource}
+          </span>
+          <div className="w-full truncate text-center text-xs">{window.DisplayTitle || window.Caption}</div>
           <div className="w-full truncate text-center text-xs">
             {window.ExePath.split("\\").pop()}
           </div>
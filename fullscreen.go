@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"tabswitcher/win32"
+)
+
+// desktopClasses are the shell windows that cover a whole monitor without
+// being a full-screen app
+var desktopClasses = []string{"Progman", "WorkerW"}
+
+// foregroundFullscreen reports whether the foreground window is a full-screen
+// app, such as a game or a video player: a window without a caption or sizing
+// border covering its whole monitor. Maximized windows keep their caption, so
+// they don't count. Exclusive-mode games can't be told apart from borderless
+// ones this way, but the switcher shouldn't pop up over either.
+func foregroundFullscreen() bool {
+	hwnd := win32.GetForegroundWindow()
+	if hwnd == 0 || hwnd == win32.GetShellWindow() {
+		return false
+	}
+	if own, err := switcherHwnd(); err == nil && own == hwnd {
+		return false
+	}
+	if class, err := windowProvider.ClassName(hwnd); err == nil && slices.Contains(desktopClasses, class) {
+		return false
+	}
+	style, err := win32.GetWindowLongPtrW(hwnd, win32.GWL_STYLE)
+	if err != nil || style&(win32.WS_CAPTION|win32.WS_THICKFRAME) != 0 {
+		return false
+	}
+
+	var rect win32.RECT
+	if err := win32.GetWindowRect(hwnd, &rect); err != nil {
+		return false
+	}
+	monitor := win32.MonitorFromWindow(hwnd, win32.MONITOR_DEFAULTTONEAREST)
+	var info win32.MONITORINFO
+	if err := win32.GetMonitorInfoW(monitor, &info); err != nil {
+		return false
+	}
+	screen := info.RcMonitor
+	return rect.Left <= screen.Left && rect.Top <= screen.Top &&
+		rect.Right >= screen.Right && rect.Bottom >= screen.Bottom
+}
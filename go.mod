@@ -3,7 +3,6 @@ module tabswitcher
 go 1.25
 
 require (
-	github.com/shahfarhadreza/go-gdiplus v0.0.0-20210421180137-228a132a1edf
 	github.com/wailsapp/wails/v3 v3.0.0-alpha.72
 	golang.org/x/sys v0.40.0
 )
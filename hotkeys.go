@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+// Hotkey maps a key chord such as "Alt+Shift+Tab" to the action name sent with
+// the systemKeyPressed event when it's pressed
+type Hotkey struct {
+	Keys   string `json:"keys"`
+	Action string `json:"action"`
+}
+
+// chord is a parsed Hotkey
+type chord struct {
+	mods   Modifiers
+	vk     uint32
+	action string
+}
+
+// keyNames maps the non-alphanumeric key names accepted in Hotkey.Keys to
+// their virtual-key codes. Letters, digits and F1-F24 are handled separately.
+var keyNames = map[string]uint32{
+	"tab":       windows.VK_TAB,
+	"`":         windows.VK_OEM_3,
+	"tilde":     windows.VK_OEM_3,
+	"esc":       windows.VK_ESCAPE,
+	"escape":    windows.VK_ESCAPE,
+	"space":     windows.VK_SPACE,
+	"enter":     windows.VK_RETURN,
+	"backspace": windows.VK_BACK,
+	"left":      windows.VK_LEFT,
+	"right":     windows.VK_RIGHT,
+	"up":        windows.VK_UP,
+	"down":      windows.VK_DOWN,
+}
+
+// parseKey returns the virtual-key code for a single key name
+func parseKey(name string) (uint32, bool) {
+	name = strings.ToLower(name)
+	if vk, ok := keyNames[name]; ok {
+		return vk, true
+	}
+	if len(name) == 1 && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= '0' && name[0] <= '9') {
+		return uint32(strings.ToUpper(name)[0]), true
+	}
+	var n uint32
+	if _, err := fmt.Sscanf(name, "f%d", &n); err == nil && n >= 1 && n <= 24 {
+		return windows.VK_F1 + n - 1, true
+	}
+	return 0, false
+}
+
+// parseChord parses "Mod+Mod+Key", e.g. "Alt+Shift+Tab". Exactly one
+// non-modifier key is required.
+func parseChord(keys string) (chord, error) {
+	var c chord
+	haveKey := false
+	for _, part := range strings.Split(keys, "+") {
+		part = strings.TrimSpace(part)
+		switch strings.ToLower(part) {
+		case "alt":
+			c.mods.Alt = true
+		case "ctrl", "control":
+			c.mods.Ctrl = true
+		case "shift":
+			c.mods.Shift = true
+		case "win":
+			c.mods.Win = true
+		default:
+			vk, ok := parseKey(part)
+			if !ok {
+				return chord{}, fmt.Errorf("unknown key %q in %q", part, keys)
+			}
+			if haveKey {
+				return chord{}, fmt.Errorf("more than one key in %q", keys)
+			}
+			c.vk, haveKey = vk, true
+		}
+	}
+	if !haveKey {
+		return chord{}, fmt.Errorf("no key in %q", keys)
+	}
+	return c, nil
+}
+
+// parseHotkeys parses the configured hotkeys, skipping invalid ones
+func parseHotkeys(hotkeys []Hotkey) ([]chord, []error) {
+	var chords []chord
+	var errs []error
+	for _, hotkey := range hotkeys {
+		c, err := parseChord(hotkey.Keys)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.action = hotkey.Action
+		chords = append(chords, c)
+	}
+	return chords, errs
+}
+
+// count returns how many modifiers are set
+func (m Modifiers) count() int {
+	n := 0
+	for _, held := range []bool{m.Alt, m.Ctrl, m.Shift, m.Win} {
+		if held {
+			n++
+		}
+	}
+	return n
+}
+
+// covers reports whether every modifier in required is also set in m
+func (m Modifiers) covers(required Modifiers) bool {
+	return (m.Alt || !required.Alt) && (m.Ctrl || !required.Ctrl) &&
+		(m.Shift || !required.Shift) && (m.Win || !required.Win)
+}
+
+// matchChord returns the chord for vk whose modifiers are all held. When
+// several match, e.g. Alt+Tab and Alt+Shift+Tab with Alt and Shift held, the
+// one requiring the most modifiers wins.
+func matchChord(chords []chord, vk uint32, held Modifiers) (chord, bool) {
+	best := -1
+	for i, c := range chords {
+		if c.vk != vk || !held.covers(c.mods) {
+			continue
+		}
+		if best < 0 || c.mods.count() > chords[best].mods.count() {
+			best = i
+		}
+	}
+	if best < 0 {
+		return chord{}, false
+	}
+	return chords[best], true
+}
+
+// systemKeys carries the actions of pressed hotkeys to the goroutine in main
+// that sends them to the frontend
+var systemKeys = make(chan string, 16)
+
+// queueSystemKey hands action over to the systemKeys consumer
+func queueSystemKey(action string) {
+	// Drop the key rather than block the hook if the consumer lags behind
+	select {
+	case systemKeys <- action:
+	default:
+	}
+}
+
+// hotkeyHandler returns the keyboard hook callback for cfg's hotkeys. Invalid
+// hotkeys are logged and left out.
+func hotkeyHandler(cfg Config) func(wParam win32.WPARAM, kbd *win32.KBDLLHOOKSTRUCT) bool {
+	chords, errs := parseHotkeys(cfg.Hotkeys)
+	for _, err := range errs {
+		log.Printf("Ignoring hotkey: %v", err)
+	}
+
+	return func(wParam win32.WPARAM, kbd *win32.KBDLLHOOKSTRUCT) bool {
+		vk := uint32(kbd.VkCode)
+		if isWinKey(vk) {
+			winKeys.track(wParam, vk)
+			return false
+		}
+
+		// Releasing Alt ends the switch and Escape dismisses the switcher,
+		// but only while it's up
+		if switcherVisible.Load() {
+			keyUp := wParam == win32.WM_SYSKEYUP || wParam == win32.WM_KEYUP
+			if isAltKey(vk) && keyUp {
+				queueSystemKey(ActionAltReleased)
+				return false
+			}
+			if vk == windows.VK_ESCAPE && !keyUp {
+				queueSystemKey(ActionEscape)
+				return true
+			}
+		}
+
+		// SYSKEYDOWN is for Alt+Key combinations & F10, KEYDOWN for the rest
+		if wParam != win32.WM_SYSKEYDOWN && wParam != win32.WM_KEYDOWN {
+			return false
+		}
+
+		held := currentModifiers()
+		held.Win = winKeys.held()
+		c, ok := matchChord(chords, vk, held)
+		if !ok {
+			return false
+		}
+		queueSystemKey(c.action)
+		return winKeys.suppress(c)
+	}
+}
+
+// isAltKey reports whether vk is either Alt key
+func isAltKey(vk uint32) bool {
+	return vk == windows.VK_MENU || vk == windows.VK_LMENU || vk == windows.VK_RMENU
+}
+
+// currentModifiers returns which modifier keys are held down right now
+func currentModifiers() Modifiers {
+	return Modifiers{
+		Alt:   win32.GetAsyncKeyState(windows.VK_MENU),
+		Ctrl:  win32.GetAsyncKeyState(windows.VK_CONTROL),
+		Shift: win32.GetAsyncKeyState(windows.VK_SHIFT),
+		Win:   win32.GetAsyncKeyState(windows.VK_LWIN) || win32.GetAsyncKeyState(windows.VK_RWIN),
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/windows"
+)
+
+// HWNDs cross the Wails bridge as decimal strings. JSON numbers are decoded
+// as float64 by JavaScript, which can't hold handles above 2^53 exactly.
+
+// formatHwnd returns the wire representation of hwnd
+func formatHwnd(hwnd windows.HWND) string {
+	return strconv.FormatUint(uint64(hwnd), 10)
+}
+
+// parseHwnd converts a handle sent by the frontend back to a windows.HWND
+func parseHwnd(s string) (windows.HWND, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window handle %q: %w", s, err)
+	}
+	return windows.HWND(n), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestHwndRoundTrip(t *testing.T) {
+	for _, hwnd := range []windows.HWND{0, 1, 0x10010, 1<<53 + 1, ^windows.HWND(0)} {
+		got, err := parseHwnd(formatHwnd(hwnd))
+		if err != nil {
+			t.Fatalf("parseHwnd(formatHwnd(%#x)): %v", hwnd, err)
+		}
+		if got != hwnd {
+			t.Errorf("round trip of %#x gave %#x", hwnd, got)
+		}
+	}
+}
+
+func TestParseHwndRejectsInvalid(t *testing.T) {
+	for _, s := range []string{"", "-1", "0x10", "1.5", "abc", "18446744073709551616"} {
+		if _, err := parseHwnd(s); err == nil {
+			t.Errorf("parseHwnd(%q) succeeded", s)
+		}
+	}
+}
+
+func TestHwndFromEventData(t *testing.T) {
+	tests := []struct {
+		name string
+		data any
+		want windows.HWND
+		ok   bool
+	}{
+		{"string", "65552", 65552, true},
+		{"bad string", "window", 0, false},
+		{"HWND", windows.HWND(42), 42, true},
+		{"uintptr", uintptr(42), 42, true},
+		{"int", 42, 42, true},
+		{"negative int", -1, 0, false},
+		{"int64", int64(42), 42, true},
+		{"negative int64", int64(-1), 0, false},
+		{"uint64", uint64(1<<60 + 1), 1<<60 + 1, true},
+		{"float64", float64(65552), 65552, true},
+		{"float64 at 2^53", float64(1 << 53), 1 << 53, true},
+		{"float64 above 2^53", float64(1<<53) * 2, 0, false},
+		{"fractional float64", 1.5, 0, false},
+		{"negative float64", float64(-1), 0, false},
+		{"json.Number", json.Number("65552"), 65552, true},
+		{"bad json.Number", json.Number("1e3"), 0, false},
+		{"nil", nil, 0, false},
+		{"map", map[string]any{"hwnd": "1"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := hwndFromEventData(tt.data)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("hwndFromEventData(%v) = %#x, %v, want %#x, %v", tt.data, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tabswitcher/win32"
+)
+
+type overrideIcon struct {
+	png         []byte
+	accentColor string
+	err         error
+}
+
+// overrideIcons caches override files by path, including the ones that failed
+// to load, so each file is only read and validated once
+var overrideIcons = struct {
+	sync.Mutex
+	byPath map[string]overrideIcon
+}{byPath: map[string]overrideIcon{}}
+
+// iconOverridePath returns the user's replacement PNG for an executable,
+// matched by full path first and then by file name, case-insensitively
+func iconOverridePath(exePath string) (string, bool) {
+	if exePath == "" || len(config.IconOverrides) == 0 {
+		return "", false
+	}
+
+	for _, key := range []string{exePath, filepath.Base(exePath)} {
+		for match, pngPath := range config.IconOverrides {
+			if strings.EqualFold(match, key) {
+				return pngPath, true
+			}
+		}
+	}
+	return "", false
+}
+
+// iconIndex returns the configured index of the icon to extract from an
+// executable, matched like iconOverridePath, or 0 for its first icon
+func iconIndex(exePath string) int32 {
+	if exePath == "" {
+		return 0
+	}
+	for _, key := range []string{exePath, filepath.Base(exePath)} {
+		for match, index := range config.IconIndexes {
+			if strings.EqualFold(match, key) {
+				return index
+			}
+		}
+	}
+	return 0
+}
+
+// loadOverrideIcon returns the override PNG at path
+func loadOverrideIcon(path string) (overrideIcon, error) {
+	overrideIcons.Lock()
+	defer overrideIcons.Unlock()
+
+	if icon, ok := overrideIcons.byPath[path]; ok {
+		return icon, icon.err
+	}
+
+	icon := overrideIcon{}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var img image.Image
+		img, err = png.Decode(bytes.NewReader(data))
+		if err == nil {
+			nrgba := image.NewNRGBA(img.Bounds())
+			draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+			icon.png = data
+			icon.accentColor = win32.IconAccentColor(nrgba)
+		}
+	}
+	if err != nil {
+		icon.err = fmt.Errorf("invalid icon override %s: %w", path, err)
+		log.Println(icon.err)
+	}
+
+	overrideIcons.byPath[path] = icon
+	return icon, icon.err
+}
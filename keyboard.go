@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"tabswitcher/win32"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// hookProbeKey is an unassigned virtual key. The watchdog injects it to
+	// check that the hook still receives input, and the hook swallows it.
+	hookProbeKey = 0x88
+
+	hookWatchdogInterval = 5 * time.Second
+	hookProbeTimeout     = 500 * time.Millisecond
+	// hookProbeBackoff is how long the watchdog waits after a probe the hook
+	// answered before probing again. GetLastInputInfo counts mouse input too,
+	// which the hook never sees, so while only the mouse is used every check
+	// would otherwise probe.
+	hookProbeBackoff = time.Minute
+
+	// wmReinstallHook asks the hook thread to reinstall the hook
+	wmReinstallHook = win32.WM_APP + 1
+	// wmSwapHook asks the hook thread to take a swap from keyboardHook.swaps
+	wmSwapHook = win32.WM_APP + 2
+)
+
+// keyboard is the running keyboard hook, nil in InputModeHotkey
+var keyboard *keyboardHook
+
+// hookSwap is a request to replace the hook's callback
+type hookSwap struct {
+	onKey func(wParam win32.WPARAM, kbd *win32.KBDLLHOOKSTRUCT) bool
+	done  chan error
+}
+
+// keyboardHook owns the low-level keyboard hook. Windows silently removes LL
+// hooks that respond too slowly, so a watchdog checks that the hook still sees
+// input and reinstalls it when it doesn't.
+type keyboardHook struct {
+	// onKey returns true to swallow the key, so no other app sees it
+	onKey func(wParam win32.WPARAM, kbd *win32.KBDLLHOOKSTRUCT) bool
+
+	// proc is created once so every reinstall reuses the same callback
+	proc     win32.HOOKPROC
+	hook     win32.HHOOK
+	threadId uint32
+
+	// lastEvent is the tick count (GetTickCount) of the last event seen
+	lastEvent atomic.Uint32
+
+	// swapMu makes swaps wait for each other, so swaps holds at most one
+	swapMu sync.Mutex
+	swaps  chan hookSwap
+}
+
+// startKeyboardHook installs the hook on a dedicated thread that pumps its
+// messages, as LL hooks are called on the installing thread
+func startKeyboardHook(onKey func(wParam win32.WPARAM, kbd *win32.KBDLLHOOKSTRUCT) bool) (*keyboardHook, error) {
+	h := &keyboardHook{onKey: onKey, swaps: make(chan hookSwap, 1)}
+	h.proc = h.callback
+	h.lastEvent.Store(win32.GetTickCount())
+
+	installed := make(chan error)
+	go h.run(installed)
+	if err := <-installed; err != nil {
+		return nil, err
+	}
+
+	go h.watchdog()
+	return h, nil
+}
+
+func (h *keyboardHook) callback(nCode int, wParam win32.WPARAM, lParam win32.LPARAM) (ret win32.LRESULT) {
+	defer func() {
+		if r := recover(); r != nil {
+			win32.LogCallbackPanic("keyboard hook", r)
+			ret = win32.CallNextHookEx(win32.HHOOK(0), nCode, wParam, lParam)
+		}
+	}()
+
+	if nCode == 0 {
+		kbd := (*win32.KBDLLHOOKSTRUCT)(unsafe.Pointer(lParam))
+		h.lastEvent.Store(uint32(kbd.Time))
+
+		if kbd.VkCode == hookProbeKey && kbd.Flags&win32.LLKHF_INJECTED != 0 {
+			return 1
+		}
+		if h.onKey(wParam, kbd) {
+			return 1
+		}
+	}
+	return win32.CallNextHookEx(win32.HHOOK(0), nCode, wParam, lParam)
+}
+
+func (h *keyboardHook) install() error {
+	hook, err := win32.SetWindowsHookExW(win32.WH_KEYBOARD_LL, h.proc, 0, 0)
+	if err != nil {
+		return err
+	}
+	h.hook = hook
+	metrics.hookInstalled.Store(true)
+	return nil
+}
+
+func (h *keyboardHook) uninstall() {
+	if h.hook == 0 {
+		return
+	}
+	win32.UnhookWindowsHookEx(h.hook)
+	h.hook = 0
+	metrics.hookInstalled.Store(false)
+}
+
+func (h *keyboardHook) run(installed chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	h.threadId = windows.GetCurrentThreadId()
+	if err := h.install(); err != nil {
+		installed <- err
+		return
+	}
+	installed <- nil
+
+	msg := &win32.MSG{}
+	for {
+		if ret, err := win32.GetMessage(msg, 0, 0, 0); ret <= 0 || err != nil {
+			break
+		}
+
+		if msg.Message == wmSwapHook {
+			request := <-h.swaps
+			request.done <- h.swap(request.onKey)
+			continue
+		}
+		if msg.Message == wmReinstallHook {
+			h.uninstall()
+			if err := h.install(); err != nil {
+				log.Printf("Failed to reinstall keyboard hook: %v", err)
+			} else {
+				log.Println("Keyboard hook reinstalled")
+			}
+			continue
+		}
+
+		win32.TranslateMessage(msg)
+		win32.DispatchMessage(msg)
+	}
+
+	h.uninstall()
+}
+
+// ReinstallHook replaces the keyboard hook with a fresh one for cfg's
+// hotkeys, e.g. after they were changed in the config. If the new hook can't
+// be installed, the old one keeps running and the error is returned.
+func ReinstallHook(cfg Config) error {
+	if keyboard == nil {
+		return errors.New("the keyboard hook isn't in use")
+	}
+	return keyboard.reinstall(hotkeyHandler(cfg))
+}
+
+// reinstall has the hook thread swap in a new hook calling onKey
+func (h *keyboardHook) reinstall(onKey func(wParam win32.WPARAM, kbd *win32.KBDLLHOOKSTRUCT) bool) error {
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+
+	done := make(chan error)
+	h.swaps <- hookSwap{onKey: onKey, done: done}
+	if err := win32.PostThreadMessageW(h.threadId, wmSwapHook, 0, 0); err != nil {
+		<-h.swaps
+		return fmt.Errorf("failed to reach the hook thread: %w", err)
+	}
+	return <-done
+}
+
+// swap installs a new hook calling onKey, then removes the old one. LL hooks
+// are only called while the hook thread pumps messages, so input arriving in
+// between waits in the queue for the new hook rather than being missed or
+// seen twice. On failure, the old hook and callback stay in place.
+func (h *keyboardHook) swap(onKey func(wParam win32.WPARAM, kbd *win32.KBDLLHOOKSTRUCT) bool) error {
+	oldHook, oldOnKey := h.hook, h.onKey
+	h.onKey = onKey
+	if err := h.install(); err != nil {
+		h.hook, h.onKey = oldHook, oldOnKey
+		return fmt.Errorf("failed to install the new keyboard hook, keeping the old one: %w", err)
+	}
+	if oldHook != 0 {
+		win32.UnhookWindowsHookEx(oldHook)
+	}
+	log.Println("Keyboard hook swapped")
+	return nil
+}
+
+// watchdog probes the hook whenever the system saw input that the hook
+// didn't. Probing only then avoids injecting input while the user is idle,
+// which would keep the screensaver and lock screen from kicking in. After a
+// probe the hook answered, the next one waits for hookProbeBackoff.
+func (h *keyboardHook) watchdog() {
+	var lastAnswered time.Time
+	for range time.Tick(hookWatchdogInterval) {
+		lastInput, err := win32.GetLastInputInfo()
+		if err != nil || int32(lastInput-h.lastEvent.Load()) <= 0 {
+			continue
+		}
+		if time.Since(lastAnswered) < hookProbeBackoff {
+			continue
+		}
+
+		if h.probe() {
+			lastAnswered = time.Now()
+			continue
+		}
+
+		log.Println("Keyboard hook stopped receiving input, reinstalling it")
+		if err := win32.PostThreadMessageW(h.threadId, wmReinstallHook, 0, 0); err != nil {
+			log.Printf("Failed to request keyboard hook reinstall: %v", err)
+		}
+	}
+}
+
+// probe injects hookProbeKey and reports whether the hook saw it
+func (h *keyboardHook) probe() bool {
+	before := h.lastEvent.Load()
+	_, err := win32.SendInput([]win32.KEYBOARDINPUT{{
+		Type: win32.INPUT_KEYBOARD,
+		Ki: win32.KEYBDINPUT{
+			WVk:     hookProbeKey,
+			DwFlags: win32.KEYEVENTF_KEYUP,
+		},
+	}})
+	if err != nil {
+		// Can't tell either way, e.g. when the secure desktop is active
+		return true
+	}
+
+	time.Sleep(hookProbeTimeout)
+	return h.lastEvent.Load() != before
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"tabswitcher/win32"
+	"testing"
+	"unsafe"
+)
+
+func TestHookCallbackRecoversFromPanic(t *testing.T) {
+	h := &keyboardHook{
+		onKey: func(wParam win32.WPARAM, kbd *win32.KBDLLHOOKSTRUCT) bool {
+			panic("onKey failed")
+		},
+	}
+	kbd := win32.KBDLLHOOKSTRUCT{VkCode: 'A'}
+
+	// The key is passed on instead of swallowed, and the panic doesn't escape
+	ret := h.callback(0, win32.WM_KEYDOWN, win32.LPARAM(unsafe.Pointer(&kbd)))
+	if ret == 1 {
+		t.Error("key was swallowed after onKey panicked")
+	}
+}
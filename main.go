@@ -1,16 +1,21 @@
 package main
 
 import (
+	"cmp"
 	"embed"
 	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"tabswitcher/win32"
 	"time"
-	"unsafe"
 
-	"github.com/shahfarhadreza/go-gdiplus"
 	"github.com/wailsapp/wails/v3/pkg/application"
 	"golang.org/x/sys/windows"
 )
@@ -24,123 +29,718 @@ import (
 var assets embed.FS
 
 type UserWindow struct {
-	touched      bool
-	IsForeground bool
-	LastActive   int
-	Hwnd         windows.HWND
-	Caption      string
-	IconBase64   string
-	IconSource   string
-	ExePath      string
+	ProcessID      uint32
+	processStart   int64
+	detailsLoaded  bool
+	firstSeen      time.Time
+	splash         bool
+	ID             uint64
+	IsForeground   bool
+	NeedsAttention bool
+	IsTopmost      bool
+	LastActive     int
+	Hwnd           windows.HWND `json:",string"`
+	Caption        string
+	// Icon is the window's icon, sent to the webview as a PNG data URL under
+	// the IconBase64 key. It's empty when the icon couldn't be rendered or,
+	// with config.LazyIcons, hasn't been loaded yet.
+	Icon       pngIcon `json:"IconBase64"`
+	IconSource string
+	IconFailed bool
+	ExePath    string
+
+	// DisplayTitle is Caption cleaned up by config.TitleRules, for display
+	DisplayTitle string
+	// Subtitle is the full path of the document the window shows, for a
+	// second line telling apart documents with the same name. It's taken from
+	// the caption, so it stays empty for apps that only show the file name.
+	Subtitle string
+	// AppUserModelID is the identity Windows uses to group windows on the
+	// taskbar, empty when the window doesn't set one explicitly
+	AppUserModelID string
+	// FriendlyName is the name of the window's app as the user knows it,
+	// see friendlyName
+	FriendlyName string
+	// AppID identifies the application the window belongs to, for grouping
+	// and SwitcherService.ExpandApp
+	AppID string
+	// Category and CategoryColor tag the window with the category of its app
+	// (see config.Categories), empty when it has none
+	Category      string
+	CategoryColor string
+	// WindowCount is the number of windows of this app in the "apps" mode
+	WindowCount int
+	// MergedCount is the number of other identical-looking windows merged
+	// into this one when config.MergeDuplicateWindows is set, so it's 1 for
+	// two merged windows and 0 for a window that had no duplicates
+	MergedCount int
+	// AccentColor is the window's caption color as "#rrggbb", falling back to
+	// IconColor when the app doesn't set one or the system can't report it
+	// (before Windows 11)
+	AccentColor string
+	// IconColor is a representative "#rrggbb" color sampled from the icon
+	IconColor string
+	// OverlayIcon is the window's taskbar overlay icon as a PNG data URL,
+	// empty when it has none or it can't be read (see overlayIconSources)
+	OverlayIcon string
+	// Dpi is the DPI of the monitor the window is on, 96 being 100% scaling
+	Dpi uint32
+	// CommandLine is the command line the window's process was started with,
+	// only read when config.ShowCommandLines is set. It stays empty when the
+	// process can't be inspected, e.g. when it's elevated and we aren't.
+	CommandLine string
+	// OffScreen is set when the window lies entirely outside every monitor,
+	// see SwitcherService.MoveOnScreen
+	OffScreen bool
+	// VisibleFraction is roughly how much of the window isn't covered by the
+	// windows above it, from 0 to 1 (see visibleFraction), and IsOccluded is
+	// set when that's under half
+	VisibleFraction float64
+	IsOccluded      bool
+	// IsDesktop marks the synthetic "Show Desktop" entry, which isn't a real
+	// window; activating it minimizes or restores all windows
+	IsDesktop bool
+
+	iconLoaded bool
+
+	// automationName is the UI Automation Name of an untitled window, looked
+	// up once when config.UIAutomationFallback is set
+	automationName    string
+	automationChecked bool
+
+	// lastSeenPass is the newest enumeration pass that saw the window, see
+	// applyPass, and zOrder its position in that pass
+	lastSeenPass uint64
+	zOrder       int
+
+	// CanActivate is a best guess at whether activating the window will work,
+	// see canActivate
+	CanActivate bool
+	elevated    bool
+
+	// LastActiveAgoMs is how long before the list was built the window was
+	// last active, or -1 if it never was as far as we know
+	LastActiveAgoMs int64
+	// ActiveThisSession tells windows activated since the switcher started
+	// apart from those whose LastActive was carried over from the previous
+	// session (see restoreLastActive)
+	ActiveThisSession bool
 }
 
 var userWindows sync.Map
 
+// userWindowsMu is held for writing while several userWindows entries are
+// changed together, so Snapshot never sees an enumeration pass half applied
+var userWindowsMu sync.RWMutex
+
+// nextWindowID hands out UserWindow.ID values. Unlike HWNDs, which Windows
+// reuses, IDs are never repeated within a session.
+var nextWindowID atomic.Uint64
+
+// colorRefToHex formats a Win32 COLORREF (0x00BBGGRR) as "#rrggbb"
+func colorRefToHex(color uint32) string {
+	return fmt.Sprintf("#%02x%02x%02x", color&0xFF, (color>>8)&0xFF, (color>>16)&0xFF)
+}
+
+// pngDataURL wraps PNG bytes in a data URL, the form the webview takes
+// images in
+func pngDataURL(data []byte) string {
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// pngIcon is an icon as PNG bytes. Only the bytes are kept, the data URL
+// the webview takes is built when the window is marshaled for it.
+type pngIcon []byte
+
+func (icon pngIcon) MarshalText() ([]byte, error) {
+	if len(icon) == 0 {
+		return []byte{}, nil
+	}
+	return []byte(pngDataURL(icon)), nil
+}
+
+// errNoIcon is returned by windowIconPNG for windows whose icon couldn't be
+// rendered, and for the desktop entry, which the frontend draws itself
+var errNoIcon = errors.New("window has no icon")
+
+// windowIconPNG returns window's icon as PNG bytes
+func windowIconPNG(window UserWindow) ([]byte, error) {
+	if window.Icon == nil {
+		return nil, errNoIcon
+	}
+	return window.Icon, nil
+}
+
+// errWindowGone is returned by loadWindowDetails when the window was destroyed
+// while its details were being read
+var errWindowGone = errors.New("window no longer exists")
+
+// loadWindowDetails fills in the expensive per-window fields other than the
+// icon: the owning process and AppUserModelID
+func loadWindowDetails(window *UserWindow) error {
+	proc := getWindowProcess(window.Hwnd)
+	if proc.exePath == "" && !windows.IsWindow(window.Hwnd) {
+		return errWindowGone
+	}
+	window.ProcessID = proc.pid
+	window.processStart = proc.startTime
+	window.elevated = proc.elevated
+	window.ExePath = proc.exePath
+
+	if config.ShowCommandLines {
+		commandLine, err := win32.GetProcessCommandLine(proc.pid)
+		if err != nil {
+			debugf("Failed to read the command line of %q: %v", window.Caption, err)
+		}
+		window.CommandLine = commandLine
+	}
+
+	aumid, err := win32.GetWindowAppUserModelID(window.Hwnd)
+	if err != nil {
+		debugf("Failed to read the AppUserModelID of %q: %v", window.Caption, err)
+	}
+	window.AppUserModelID = aumid
+	window.FriendlyName = friendlyName(*window)
+	window.detailsLoaded = true
+	return nil
+}
+
+// loadWindowIcon fills in the window's icon. It returns how long getting the
+// icon took, which is mostly spent waiting on the window to answer
+// WM_GETICON.
+func loadWindowIcon(window *UserWindow) (time.Duration, error) {
+	var iconTime time.Duration
+	if path, ok := iconOverridePath(window.ExePath); ok {
+		if icon, err := loadOverrideIcon(path); err == nil {
+			window.IconSource = "override"
+			window.Icon = icon.png
+			window.IconColor = icon.accentColor
+			window.iconLoaded = true
+			return iconTime, nil
+		}
+	}
+
+	iconStart := time.Now()
+	iconInfo := win32.GetWindowIcon(window.Hwnd, window.ExePath, iconIndex(window.ExePath), iconSourcesFor(window.Hwnd))
+	iconTime = time.Since(iconStart)
+	window.IconSource = iconInfo.Source
+	encoded, err := win32.EncodeIcon(iconInfo.Icon)
+	if iconInfo.Owned {
+		win32.DestroyIcon(iconInfo.Icon)
+	}
+	if err != nil && !windows.IsWindow(window.Hwnd) {
+		return iconTime, errWindowGone
+	}
+	if err != nil {
+		// Keep the window switchable, the frontend shows a placeholder
+		debugf("Icon extraction from %s failed for %q: %v", iconInfo.Source, window.Caption, err)
+		window.IconFailed = true
+	} else {
+		window.Icon = encoded.PNG
+		window.IconColor = encoded.AccentColor
+	}
+
+	window.iconLoaded = true
+	return iconTime, nil
+}
+
+// takeIcon copies the icon fields loaded by loadWindowIcon from other
+func (w *UserWindow) takeIcon(other UserWindow) {
+	w.IconSource = other.IconSource
+	w.Icon = other.Icon
+	w.IconColor = other.IconColor
+	w.IconFailed = other.IconFailed
+	w.iconLoaded = other.iconLoaded
+}
+
+// GetWindowIconByID returns the icon of the window with the given ID as PNG
+// bytes. With config.LazyIcons, enumeration leaves the icons out and they're
+// loaded here the first time they're asked for, then kept with the window.
+func GetWindowIconByID(id uint64) ([]byte, error) {
+	window, ok := findWindowByID(id)
+	if !ok {
+		return nil, fmt.Errorf("no window with ID %d", id)
+	}
+	if window.iconLoaded || window.IsDesktop {
+		return windowIconPNG(window)
+	}
+
+	if _, err := loadWindowIcon(&window); err != nil {
+		return nil, err
+	}
+	userWindowsMu.Lock()
+	if current, ok := FindWindow(window.Hwnd); ok && current.ID == window.ID {
+		current.takeIcon(window)
+		userWindows.Store(window.Hwnd, current)
+	}
+	userWindowsMu.Unlock()
+	return windowIconPNG(window)
+}
+
+// Windows without a caption, not resizable and no larger than this are
+// treated as splash screens
+const (
+	maxSplashWidth  = 800
+	maxSplashHeight = 600
+)
+
+// looksLikeSplash reports whether a new window is likely an app's splash
+// screen, which shows up only briefly while the app starts
+func looksLikeSplash(hwnd windows.HWND, caption string) bool {
+	if caption != "" {
+		return false
+	}
+
+	style, err := win32.GetWindowLongPtrW(hwnd, win32.GWL_STYLE)
+	if err != nil || style&win32.WS_THICKFRAME != 0 {
+		return false
+	}
+
+	var rect win32.RECT
+	if err := win32.GetWindowRect(hwnd, &rect); err != nil {
+		return false
+	}
+	return rect.Right-rect.Left <= maxSplashWidth && rect.Bottom-rect.Top <= maxSplashHeight
+}
+
+// tooSmall reports whether hwnd is below config.MinWindowSize. Minimized
+// windows are parked by Windows at a size of their own (about 160x28) and
+// don't count, and windows whose bounds can't be read are given the benefit
+// of the doubt.
+func tooSmall(hwnd windows.HWND) bool {
+	if windowProvider.IsIconic(hwnd) {
+		return false
+	}
+	rect, err := windowProvider.Bounds(hwnd)
+	if err != nil {
+		return false
+	}
+	return rect.Right-rect.Left < config.MinWindowSize.Width ||
+		rect.Bottom-rect.Top < config.MinWindowSize.Height
+}
+
+// settled reports whether a window has been around long enough to be listed,
+// which keeps short-lived windows like splash screens from flashing into the
+// list and back out again
+func (w UserWindow) settled(now time.Time) bool {
+	delay := time.Duration(config.NewWindowDelayMs) * time.Millisecond
+	if w.splash {
+		delay = time.Duration(config.SplashWindowDelayMs) * time.Millisecond
+	}
+	return now.Sub(w.firstSeen) >= delay
+}
+
+// process returns the identity of the process owning the window
+func (w UserWindow) process() processKey {
+	return processKey{w.ProcessID, w.processStart}
+}
+
+// FindWindow returns the tracked window for hwnd. It doesn't take
+// userWindowsMu, so it can be used while holding it.
+func FindWindow(hwnd windows.HWND) (UserWindow, bool) {
+	win, ok := userWindows.Load(hwnd)
+	if !ok {
+		return UserWindow{}, false
+	}
+	return win.(UserWindow), true
+}
+
+// findWindowByID maps a stable session ID back to its current window
+func findWindowByID(id uint64) (UserWindow, bool) {
+	if id == desktopEntryID && config.ShowDesktopEntry {
+		return desktopEntry(), true
+	}
+	for _, window := range Snapshot() {
+		if window.ID == id {
+			return window, true
+		}
+	}
+	return UserWindow{}, false
+}
+
+// uniqueHwnds drops repeated handles from hwnds, keeping the first (topmost)
+// occurrence, so no window is processed twice in a pass and listed twice
+func uniqueHwnds(hwnds []windows.HWND) []windows.HWND {
+	seen := make(map[windows.HWND]bool, len(hwnds))
+	unique := make([]windows.HWND, 0, len(hwnds))
+	for _, hwnd := range hwnds {
+		if !seen[hwnd] {
+			seen[hwnd] = true
+			unique = append(unique, hwnd)
+		}
+	}
+	return unique
+}
+
+// enumerationPasses numbers the GetAltTabWindows passes in the order they
+// start
+var enumerationPasses atomic.Uint64
+
+// appliedPass is the number of the pass userWindows was last replaced with.
+// Guarded by userWindowsMu.
+var appliedPass uint64
+
+// maxTrackedWindows caps how many windows a pass keeps track of. Desktops
+// stay far below it, it only bounds userWindows when something floods the
+// desktop with windows.
+const maxTrackedWindows = 2048
+
+// enumeratedOnce is set after the first successful GetAltTabWindows pass
+var enumeratedOnce atomic.Bool
+
+// windowProvider is where GetAltTabWindows gets its windows from
+var windowProvider = win32.SystemWindows
+
 func init() {
 	// Register a custom event whose associated data type is string.
 	// This is not required, but the binding generator will pick up registered events
 	// and provide a strongly typed JS/TS API for them.
 	application.RegisterEvent[[]UserWindow]("userWindowsChanged")
+	application.RegisterEvent[[]AppGroup]("appGroupsChanged")
 	application.RegisterEvent[string]("systemKeyPressed")
-	application.RegisterEvent[windows.HWND]("activateWindow")
+	application.RegisterEvent[string]("activateWindow")
+	application.RegisterEvent[string]("windowEnumerationFailed")
+	application.RegisterEvent[application.Void]("userWindowsEmpty")
+	application.RegisterEvent[string]("selectionChanged")
+	application.RegisterEvent[string]("windowActivationFailed")
 }
 
-var (
-	gdipInput  = gdiplus.GdiplusStartupInput{GdiplusVersion: 1}
-	gdipOutput = gdiplus.GdiplusStartupOutput{}
-	pngClsId   = &windows.GUID{}
-)
-
-func GetAltTabWindows() []UserWindow {
-	foreground := win32.GetForegroundWindow()
+// Snapshot returns a copy of every tracked window, as left by the last
+// complete enumeration pass, in that pass's Z-order
+func Snapshot() []UserWindow {
+	userWindowsMu.RLock()
+	defer userWindowsMu.RUnlock()
 
+	var userWindowsSlice []UserWindow
 	userWindows.Range(func(key, val any) bool {
-		window := val.(UserWindow)
-		window.touched = false
-		userWindows.Store(key, window)
+		userWindowsSlice = append(userWindowsSlice, val.(UserWindow))
 		return true
 	})
+	slices.SortFunc(userWindowsSlice, func(a, b UserWindow) int {
+		return cmp.Compare(a.zOrder, b.zOrder)
+	})
+	return userWindowsSlice
+}
+
+// listedWindows returns the windows of the last enumeration pass that are
+// listed, as GetAltTabWindows would, without enumerating again
+func listedWindows() []UserWindow {
+	now := time.Now()
+	var listed []UserWindow
+	for _, window := range Snapshot() {
+		if window.settled(now) {
+			window.updateActivity(now)
+			listed = append(listed, window)
+		}
+	}
+	return listed
+}
 
-	for res := range win32.ListDesktopWindows() {
-		if res.Error != nil {
-			log.Printf("Error enumerating windows: %v", res.Error)
+// GetAltTabWindows enumerates the desktop and refreshes userWindows. If the
+// enumeration itself fails, the previous list is returned unchanged along with
+// the error, so a failure isn't mistaken for "no windows open".
+func GetAltTabWindows() ([]UserWindow, error) {
+	start := time.Now()
+	pass := enumerationPasses.Add(1)
+	var iconTimes []windowTiming
+	defer func() {
+		recordEnumeration(time.Since(start), iconTimes)
+	}()
+
+	foreground := win32.GetForegroundWindow()
+
+	hwnds, err := windowProvider.EnumWindows()
+	if err != nil {
+		log.Printf("Error enumerating windows, keeping the previous list: %v", err)
+		return Snapshot(), err
+	}
+	hwnds = uniqueHwnds(hwnds)
+	zOrder := zOrderRects(hwnds)
+
+	// Windows that were already open when we started are listed right away
+	firstSeen := start
+	if !enumeratedOnce.Load() {
+		firstSeen = time.Time{}
+	}
+
+	var onCurrentDesktop map[windows.HWND]bool
+	if virtualDesktopsAvailable {
+		onCurrentDesktop, err = win32.WindowsOnCurrentDesktop(hwnds)
+		if err != nil {
+			log.Printf("Failed to check virtual desktops: %v", err)
+		}
+	}
+
+	workAreas, err := win32.MonitorWorkAreas()
+	if err != nil {
+		log.Printf("Failed to list monitors, not checking for off-screen windows: %v", err)
+	}
+
+	// Owner processes of the windows seen so far, so each process's start
+	// time is only read once per pass
+	processes := make(map[uint32]processKey)
+	ownerOf := func(pid uint32) processKey {
+		key, ok := processes[pid]
+		if !ok {
+			key = processKeyOf(pid)
+			processes[pid] = key
+		}
+		return key
+	}
+
+	// The pass is collected here, in Z-order, and applied to userWindows all
+	// at once
+	var listed []UserWindow
+	for _, hWnd := range hwnds {
+		if !win32.IsAltTabWindowFor(windowProvider, hWnd) || tooSmall(hWnd) {
 			continue
 		}
 
-		hWnd := res.Window
-		if win32.IsAltTabWindow(hWnd) {
-			caption := make([]uint16, 256)
-			_, err := win32.GetWindowTextW(hWnd, &caption[0], int32(len(caption)))
-			if err != nil {
+		capStr, err := windowProvider.Caption(hWnd)
+		if err != nil {
+			continue
+		}
+		isOffScreen := offScreen(hWnd, workAreas)
+		if isOffScreen && skipOffScreen(capStr) {
+			continue
+		}
+
+		var processId win32.DWORD
+		win32.GetWindowThreadProcessId(hWnd, &processId)
+
+		window, ok := FindWindow(hWnd)
+		if !ok || window.process() != ownerOf(uint32(processId)) {
+			// New window, or the handle was recycled by another process (one
+			// that may even have been given the same PID), so this is a new
+			// window as far as the frontend is concerned
+			window = UserWindow{
+				ID:        nextWindowID.Add(1),
+				Hwnd:      hWnd,
+				firstSeen: firstSeen,
+				splash:    looksLikeSplash(hWnd, capStr),
+			}
+		}
+
+		// Only the cheap, frequently changing state is refreshed on every
+		// pass, the process and icon lookups are done once per window
+		if !window.detailsLoaded {
+			// The window may have been closed since it was enumerated, it's
+			// just left out of this pass rather than reported as a failure
+			if !windows.IsWindow(hWnd) {
 				continue
 			}
-			capStr := windows.UTF16ToString(caption)
-
-			// Get the executable path for this window
-			var processId win32.DWORD
-			win32.GetWindowThreadProcessId(hWnd, &processId)
-			exePath := ""
-			hProcess, err := windows.OpenProcess(win32.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(processId))
-			if err == nil {
-				defer windows.CloseHandle(hProcess)
-				var exePathBuf [win32.MAX_PATH]uint16
-				exePathSize := win32.DWORD(win32.MAX_PATH)
-				err = win32.QueryFullProcessImageNameW(hProcess, 0, &exePathBuf[0], &exePathSize)
-				if err == nil {
-					exePath = windows.UTF16ToString(exePathBuf[:])
-				}
+			window.Caption = capStr
+			if err := loadWindowDetails(&window); errors.Is(err, errWindowGone) {
+				continue
 			}
+			metrics.detailMisses.Add(1)
+		} else {
+			metrics.detailHits.Add(1)
+		}
+
+		if processHidden(window.ExePath) {
+			continue
+		}
+
+		if !window.iconLoaded && !config.LazyIcons {
+			iconTime, err := loadWindowIcon(&window)
+			if errors.Is(err, errWindowGone) {
+				continue
+			}
+			iconTimes = append(iconTimes, windowTiming{window.Caption, window.ExePath, iconTime})
+		}
 
-			iconInfo := win32.GetWindowIcon(hWnd, exePath)
-			iconB64, err := win32.HICONToBase64Png(iconInfo.Icon, pngClsId)
+		window.Caption = capStr
+		if capStr == "" && config.UIAutomationFallback && !window.automationChecked {
+			name, err := win32.GetWindowAutomationName(hWnd)
 			if err != nil {
+				debugf("UI Automation name lookup failed for window %v: %v", hWnd, err)
+			}
+			window.automationName = name
+			window.automationChecked = true
+		}
+		if capStr == "" {
+			// Untitled windows are only listed if they can go by their UI
+			// Automation name or their app's name
+			name := window.automationName
+			if name == "" {
+				name = window.FriendlyName
+			}
+			if name == "" {
 				continue
 			}
+			window.Caption = name
+		}
+		window.DisplayTitle = displayTitle(window)
+		window.Subtitle = documentPath(window.Caption)
+		category := windowCategory(window.ExePath)
+		window.Category, window.CategoryColor = category.Label, category.Color
+		window.OverlayIcon = overlayIcon(window)
+		if !enumeratedOnce.Load() && window.LastActive == 0 {
+			// Windows that were open in the previous session keep their order
+			window.LastActive = restoreLastActive(window)
+		}
+		window.IsForeground = foreground == hWnd
+		window.OffScreen = isOffScreen
+		window.VisibleFraction = visibleFraction(hWnd, zOrder)
+		window.IsOccluded = window.VisibleFraction < occludedBelow
+		exStyle, err := win32.GetWindowLongPtrW(hWnd, win32.GWL_EXSTYLE)
+		window.IsTopmost = err == nil && exStyle&win32.WS_EX_TOPMOST != 0
+		window.NeedsAttention = needsAttention(hWnd)
+		window.Dpi = win32.GetWindowDpi(hWnd)
+		onCurrent, ok := onCurrentDesktop[hWnd]
+		window.CanActivate = canActivate(window, onCurrent || !ok)
+		window.AccentColor = window.IconColor
+		if color, ok := win32.GetWindowAccentColor(hWnd); ok {
+			window.AccentColor = colorRefToHex(color)
+		}
+		listed = append(listed, window)
+	}
+
+	listed, applied := applyPass(pass, listed)
+	if !applied {
+		// A newer pass finished first, and its windows are the current ones
+		listed = Snapshot()
+	}
+
+	if !enumeratedOnce.Swap(true) {
+		forgetSavedMRU()
+	}
+
+	// Return the windows in enumeration order, which is their Z-order
+	now := time.Now()
+	userWindowsSlice := make([]UserWindow, 0, len(listed))
+	for _, window := range listed {
+		if window.settled(start) {
+			window.updateActivity(now)
+			userWindowsSlice = append(userWindowsSlice, window)
+		}
+	}
 
-			isForeground := foreground == hWnd
-
-			win, ok := userWindows.Load(hWnd)
-			if ok {
-				window := win.(UserWindow)
-				window.touched = true
-				window.Caption = capStr
-				window.IconBase64 = "data:image/png;base64," + iconB64
-				window.IconSource = iconInfo.Source
-				window.IsForeground = isForeground
-				window.ExePath = exePath
-				userWindows.Store(hWnd, window)
-			} else {
-				userWindows.Store(hWnd, UserWindow{
-					touched:      true,
-					Hwnd:         hWnd,
-					Caption:      capStr,
-					IconBase64:   "data:image/png;base64," + iconB64,
-					IconSource:   iconInfo.Source,
-					IsForeground: isForeground,
-					ExePath:      exePath,
-				})
+	return userWindowsSlice, nil
+}
+
+// applyPass replaces userWindows with listed, the windows seen by pass in
+// Z-order, and returns them as stored. Passes can overlap, and one that
+// started earlier but finished later would bring back windows the newer pass
+// found closed, so only the newest pass is applied and ok is false for the
+// others. Every window is stamped with the pass that saw it, and those not
+// seen by the newest pass are evicted by that stamp, whichever way they were
+// stored in the meantime. At most maxTrackedWindows windows are kept.
+func applyPass(pass uint64, listed []UserWindow) (stored []UserWindow, ok bool) {
+	userWindowsMu.Lock()
+	defer userWindowsMu.Unlock()
+
+	if pass <= appliedPass {
+		return nil, false
+	}
+	appliedPass = pass
+
+	if len(listed) > maxTrackedWindows {
+		log.Printf("Tracking only the top %d of %d windows", maxTrackedWindows, len(listed))
+		listed = listed[:maxTrackedWindows]
+	}
+	for i, window := range listed {
+		// Keep activations recorded and icons loaded (GetWindowIconByID)
+		// while this pass was running
+		if current, ok := FindWindow(window.Hwnd); ok && current.ID == window.ID {
+			window.LastActive = current.LastActive
+			if current.iconLoaded && !window.iconLoaded {
+				window.takeIcon(current)
 			}
 		}
+		window.lastSeenPass, window.zOrder = pass, i
+		listed[i] = window
+		userWindows.Store(window.Hwnd, window)
 	}
 
-	var userWindowsSlice []UserWindow
 	userWindows.Range(func(key, val any) bool {
-		window := val.(UserWindow)
-		if window.touched {
-			userWindowsSlice = append(userWindowsSlice, window)
-		} else {
+		if val.(UserWindow).lastSeenPass < pass {
 			userWindows.Delete(key)
 		}
 		return true
 	})
+	return listed, true
+}
 
-	return userWindowsSlice
+// hwndFromEventData extracts a window handle from an event payload. The
+// frontend sends the string form from UserWindow.Hwnd (see formatHwnd), but
+// plain JS numbers, which arrive as float64 once decoded from JSON, and Go
+// values emitted in-process are accepted too.
+func hwndFromEventData(data any) (windows.HWND, bool) {
+	switch v := data.(type) {
+	case string:
+		hwnd, err := parseHwnd(v)
+		return hwnd, err == nil
+	case windows.HWND:
+		return v, true
+	case uintptr:
+		return windows.HWND(v), true
+	case int:
+		if v < 0 {
+			return 0, false
+		}
+		return windows.HWND(v), true
+	case int64:
+		if v < 0 {
+			return 0, false
+		}
+		return windows.HWND(v), true
+	case uint64:
+		return windows.HWND(v), true
+	case float64:
+		// Anything above 2^53 may already have been rounded to another handle
+		if v < 0 || v > 1<<53 || v != math.Trunc(v) {
+			return 0, false
+		}
+		return windows.HWND(v), true
+	case json.Number:
+		hwnd, err := parseHwnd(v.String())
+		return hwnd, err == nil
+	}
+	return 0, false
+}
+
+// listWasEmpty remembers whether the last emitted window list was empty
+var listWasEmpty atomic.Bool
+
+// emitUserWindows sends the current window list to the frontend along with
+// the windows grouped by application, followed by a windowEnumerationFailed
+// event when the list couldn't be refreshed, or a userWindowsEmpty event when
+// there is nothing left to switch to
+func emitUserWindows(app *application.App) {
+	windows, err := windowsForMode(config.SwitchMode)
+	app.Event.Emit("userWindowsChanged", windows)
+	// Grouped from the pass windowsForMode just made, without enumerating again
+	app.Event.Emit("appGroupsChanged", sortedAppGroups(listedWindows()))
+	if err != nil {
+		app.Event.Emit("windowEnumerationFailed", err.Error())
+		return
+	}
+
+	// Only signal the transition, not every poll while nothing is open. The
+	// desktop entry doesn't count as something to switch to.
+	empty := len(windows) == 0 || len(windows) == 1 && windows[0].IsDesktop
+	if empty && !listWasEmpty.Load() {
+		app.Event.Emit("userWindowsEmpty")
+	}
+	listWasEmpty.Store(empty)
 }
 
 // main function serves as the application's entry point. It initializes the application, creates a window,
 // and starts a goroutine that emits a time-based event every second. It subsequently runs the application and
 // logs any error that might occur.
 func main() {
+	config = loadConfig()
+	windowProvider = providerFor(config.EnumerationBackend)
+	virtualDesktopsAvailable = win32.VirtualDesktopsAvailable()
+	if err := loadMRU(); err != nil {
+		log.Printf("Failed to load the saved MRU order: %v", err)
+	}
+	selfElevated = windows.GetCurrentProcessToken().IsElevated()
+	if level, err := win32.GetTokenIntegrityLevel(windows.GetCurrentProcessToken()); err == nil {
+		selfIntegrity = level
+	}
 
 	// Create a new Wails application by providing the necessary options.
 	// Variables 'Name' and 'Description' are for application metadata.
@@ -152,6 +752,7 @@ func main() {
 		Description: "A demo of using raw HTML & CSS",
 		Services: []application.Service{
 			application.NewService(&GreetService{}),
+			application.NewService(&SwitcherService{}),
 		},
 		Assets: application.AssetOptions{
 			Handler: application.AssetFileServerFS(assets),
@@ -166,7 +767,7 @@ func main() {
 	// 'Mac' options tailor the window when running on macOS.
 	// 'BackgroundColour' is the background colour of the window.
 	// 'URL' is the URL that will be loaded into the webview.
-	window := app.Window.NewWithOptions(application.WebviewWindowOptions{
+	switcherWindow = app.Window.NewWithOptions(application.WebviewWindowOptions{
 		// BackgroundColour: application.NewRGBA(239, 244, 249, 50),
 		BackgroundType:  application.BackgroundTypeTranslucent,
 		URL:             "/",
@@ -182,33 +783,23 @@ func main() {
 	})
 	log.Println("Application set up finished.")
 
-	window.Show()
+	switcherVisible.Store(true)
+	switcherWindow.Show()
 
 	app.Event.On("activateWindow", func(event *application.CustomEvent) {
-		hwnd := event.Data.(windows.HWND)
-		success := win32.SetForegroundWindow(hwnd)
-		if !success {
-			log.Printf("Failed to set window %v to foreground\n", hwnd)
+		hwnd, ok := hwndFromEventData(event.Data)
+		if !ok {
+			log.Printf("Ignoring activateWindow event with bad payload %#v", event.Data)
 			return
 		}
-
-		win, ok := userWindows.Load(hwnd)
-		if ok {
-			window := win.(UserWindow)
-			window.LastActive = int(time.Now().UnixMilli())
-			userWindows.Store(hwnd, window)
-
-			log.Printf("Activated window: %s\n", window.Caption)
-			app.Event.Emit("userWindowsChanged", GetAltTabWindows())
+		if err := activateWindow(app, hwnd); err != nil {
+			log.Println(err)
+			// Carries the window's Hwnd, so the frontend can keep it selected
+			app.Event.Emit("windowActivationFailed", formatHwnd(hwnd))
 		}
 	})
 
-	ret := gdiplus.GdiplusStartup(&gdipInput, &gdipOutput)
-	fmt.Println(ret.String())
-	defer gdiplus.GdiplusShutdown()
-
-	clsId, err := win32.GetEncoderClsid("image/png")
-	pngClsId = clsId
+	var err error
 
 	// Create a goroutine that emits an event containing the current time every second.
 	// The frontend can listen to this event and update the UI accordingly.
@@ -220,60 +811,62 @@ func main() {
 		}
 	}()
 
-	hook, err := win32.SetWindowsHookExW(
-		win32.WH_KEYBOARD_LL,
-		(win32.HOOKPROC)(func(nCode int, wParam win32.WPARAM, lParam win32.LPARAM) win32.LRESULT {
-			// SYSKEYDOWN is for Alt+Key combinations & F10
-			if nCode == 0 && wParam == win32.WM_SYSKEYDOWN {
-				fmt.Print("key pressed:")
-				kbdstruct := (*win32.KBDLLHOOKSTRUCT)(unsafe.Pointer(lParam))
-				code := byte(kbdstruct.VkCode)
-				if code == windows.VK_TAB {
-					app.Event.Emit("systemKeyPressed", "tab")
-					fmt.Printf("(tab)")
-				}
-				if code == windows.VK_OEM_3 {
-					app.Event.Emit("systemKeyPressed", "tilde")
-					fmt.Printf("(`~)")
-				}
-				fmt.Printf("%q\n", code)
-			}
-			return win32.CallNextHookEx(win32.HHOOK(0), nCode, wParam, lParam)
-		}),
-		0,
-		0,
-	)
-	if err != nil {
-		log.Fatal("Failed to set keyboard hook:", err)
+	startShellHook()
+
+	if config.MetricsPort != 0 {
+		startMetricsServer(config.MetricsPort)
+	}
+	if config.DebugLogging {
+		go compareEnumerationBackends()
 	}
-	log.Println("Keyboard hook installed")
 
+	// The hook callback runs inline with all keyboard input on the system, so
+	// it only queues actions (see queueSystemKey) and everything else happens
+	// off the hook thread
 	go func() {
-		msg := &win32.MSG{}
-		for {
-			if _, err := win32.GetMessage(msg, 0, 0, 0); err != nil {
-				break
+		for action := range systemKeys {
+			if config.SuppressOverFullscreen && foregroundFullscreen() {
+				log.Printf("Hotkey pressed over a full-screen app, ignoring it: %s", action)
+				continue
+			}
+			log.Printf("Hotkey pressed: %s", action)
+			if handleSystemKey(app, action) {
+				app.Event.Emit("systemKeyPressed", action)
 			}
-
-			win32.TranslateMessage(msg)
-			win32.DispatchMessage(msg)
 		}
-
-		win32.UnhookWindowsHookEx(hook)
-		hook = 0
 	}()
 
-	go func() {
-		for {
-			app.Event.Emit("userWindowsChanged", GetAltTabWindows())
-			<-time.After(time.Second)
+	if config.SuppressWinHotkeys {
+		log.Println("Warning: Win hotkeys are swallowed, Windows' own shortcuts for the same keys (e.g. Win+Tab for Task View) won't work")
+	}
+
+	if config.InputMode == InputModeHotkey {
+		chords, errs := parseHotkeys(config.Hotkeys)
+		for _, err := range errs {
+			log.Printf("Ignoring hotkey: %v", err)
 		}
-	}()
+		if err := startRegisteredHotkeys(chords, queueSystemKey); err != nil {
+			log.Fatal("Failed to register hotkeys:", err)
+		}
+		go pollSwitcherKeys(queueSystemKey)
+	} else {
+		keyboard, err = startKeyboardHook(hotkeyHandler(config))
+		if err != nil {
+			log.Fatal("Failed to set keyboard hook:", err)
+		}
+		log.Println("Keyboard hook installed")
+	}
+
+	startWindowUpdates(app)
 
 	// Run the application. This blocks until the application has been exited.
 	log.Println("Running the application...")
 	err = app.Run()
 
+	if err := saveMRU(); err != nil {
+		log.Printf("Failed to save the MRU order: %v", err)
+	}
+
 	// If an error occurred while running the application, log it and exit.
 	if err != nil {
 		log.Fatal(err)
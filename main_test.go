@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"slices"
+	"tabswitcher/win32"
+	"tabswitcher/win32/win32test"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+// useProvider makes p the windowProvider for the rest of the test
+func useProvider(t *testing.T, p win32.WindowProvider) {
+	previous := windowProvider
+	windowProvider = p
+	t.Cleanup(func() { windowProvider = previous })
+}
+
+// useConfig applies change to config for the rest of the test
+func useConfig(t *testing.T, change func(c *Config)) {
+	previous := config
+	change(&config)
+	t.Cleanup(func() { config = previous })
+}
+
+// sized returns bounds of width by height at the top left of the screen
+func sized(width, height int32) win32.RECT {
+	return win32.RECT{Right: width, Bottom: height}
+}
+
+func TestTooSmall(t *testing.T) {
+	useConfig(t, func(c *Config) { c.MinWindowSize = WindowSize{Width: 48, Height: 48} })
+	useProvider(t, &win32test.FakeProvider{Windows: []win32test.FakeWindow{
+		{Hwnd: 1, Bounds: sized(1, 1)},
+		{Hwnd: 2, Bounds: sized(47, 600)},
+		{Hwnd: 3, Bounds: sized(800, 47)},
+		{Hwnd: 4, Bounds: sized(48, 48)},
+		{Hwnd: 5, Bounds: sized(1280, 720)},
+		{Hwnd: 6, Bounds: win32.RECT{Left: -1600, Top: 200, Right: -800, Bottom: 800}},
+		// Minimized, at the size Windows parks it at
+		{Hwnd: 8, Bounds: sized(160, 28), Iconic: true},
+	}})
+
+	tests := []struct {
+		hwnd windows.HWND
+		want bool
+	}{
+		{1, true},
+		{2, true},
+		{3, true},
+		{4, false},
+		{5, false},
+		// Off-screen but large enough
+		{6, false},
+		// Bounds that can't be read don't hide the window
+		{7, false},
+		{8, false},
+	}
+	for _, tt := range tests {
+		if got := tooSmall(tt.hwnd); got != tt.want {
+			t.Errorf("tooSmall(%v) = %v, want %v", tt.hwnd, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueHwnds(t *testing.T) {
+	// The first, topmost occurrence is the one kept
+	hwnds := []windows.HWND{3, 1, 3, 2, 1}
+	got := uniqueHwnds(hwnds)
+	want := []windows.HWND{3, 1, 2}
+	if !slices.Equal(got, want) {
+		t.Errorf("uniqueHwnds(%v) = %v, want %v", hwnds, got, want)
+	}
+
+	if got := uniqueHwnds(nil); len(got) != 0 {
+		t.Errorf("uniqueHwnds(nil) = %v", got)
+	}
+}
+
+func TestDuplicateEnumerationIsListedOnce(t *testing.T) {
+	// A real window of this process, so the process and window lookups
+	// GetAltTabWindows makes past the provider succeed. It's destroyed on the
+	// thread that created it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	class, _ := windows.UTF16PtrFromString("STATIC")
+	hwnd, err := win32.CreateWindowExW(0, class, nil, 0, 0, 0, 400, 300, 0, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("CreateWindowExW: %v", err)
+	}
+	defer win32.DestroyWindow(hwnd)
+
+	resetUserWindows(t)
+	useConfig(t, func(c *Config) {
+		c.NewWindowDelayMs = 0
+		c.SplashWindowDelayMs = 0
+		c.LazyIcons = true
+	})
+	window := win32test.FakeWindow{Hwnd: hwnd, ClassName: "Static", Caption: "Listed once", Visible: true, Bounds: sized(400, 300)}
+	useProvider(t, &win32test.FakeProvider{Windows: []win32test.FakeWindow{window, window}})
+
+	list, err := GetAltTabWindows()
+	if err != nil {
+		t.Fatalf("GetAltTabWindows: %v", err)
+	}
+	if got := hwndsOf(list); !slices.Equal(got, []windows.HWND{hwnd}) {
+		t.Errorf("listed %v, want %v once", got, hwnd)
+	}
+}
+
+func TestIconMarshalsAsDataURL(t *testing.T) {
+	tests := []struct {
+		icon pngIcon
+		want string
+	}{
+		{pngIcon{0x89, 'P', 'N', 'G'}, "data:image/png;base64,iVBORw=="},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(UserWindow{Icon: tt.icon})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields struct{ IconBase64 string }
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if fields.IconBase64 != tt.want {
+			t.Errorf("icon %v marshaled as %q, want %q", tt.icon, fields.IconBase64, tt.want)
+		}
+	}
+}
+
+// resetUserWindows empties userWindows for the test and restores it after
+func resetUserWindows(t *testing.T) {
+	previous := Snapshot()
+	previousPass := appliedPass
+	empty := func() {
+		userWindows.Range(func(key, val any) bool {
+			userWindows.Delete(key)
+			return true
+		})
+	}
+	empty()
+	t.Cleanup(func() {
+		empty()
+		for _, window := range previous {
+			userWindows.Store(window.Hwnd, window)
+		}
+		appliedPass = previousPass
+	})
+}
+
+// transientWindows returns n windows that only live for one pass
+func transientWindows(pass uint64, n int) []UserWindow {
+	list := make([]UserWindow, 0, n)
+	for i := range n {
+		hwnd := windows.HWND(pass<<20 | uint64(i))
+		list = append(list, UserWindow{ID: uint64(hwnd), Hwnd: hwnd})
+	}
+	return list
+}
+
+func TestTransientWindowsAreEvicted(t *testing.T) {
+	resetUserWindows(t)
+	pass := appliedPass
+
+	steady := UserWindow{ID: 1, Hwnd: 1}
+	for range 50 {
+		pass++
+		list := append([]UserWindow{steady}, transientWindows(pass, 100)...)
+		if _, ok := applyPass(pass, list); !ok {
+			t.Fatalf("pass %d wasn't applied", pass)
+		}
+		if n := len(Snapshot()); n != len(list) {
+			t.Fatalf("%d windows tracked after pass %d, want %d", n, pass, len(list))
+		}
+	}
+	if _, ok := FindWindow(steady.Hwnd); !ok {
+		t.Error("the window seen by every pass was evicted")
+	}
+
+	// A flood of windows within a single pass is capped
+	pass++
+	stored, ok := applyPass(pass, transientWindows(pass, maxTrackedWindows+500))
+	if !ok || len(stored) != maxTrackedWindows {
+		t.Errorf("stored %d windows, %v, want %d, true", len(stored), ok, maxTrackedWindows)
+	}
+	if n := len(Snapshot()); n != maxTrackedWindows {
+		t.Errorf("%d windows tracked after the flood, want %d", n, maxTrackedWindows)
+	}
+
+	// A pass that finishes after a newer one isn't applied, so it can't bring
+	// back the windows it saw
+	if _, ok := applyPass(pass-1, transientWindows(pass-1, 100)); ok {
+		t.Error("an out of date pass was applied")
+	}
+	if _, ok := FindWindow(transientWindows(pass-1, 1)[0].Hwnd); ok {
+		t.Error("an out of date pass brought back a window")
+	}
+
+	// The flood is gone by the next pass
+	pass++
+	applyPass(pass, []UserWindow{steady})
+	if n := len(Snapshot()); n != 1 {
+		t.Errorf("%d windows tracked, want 1", n)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"tabswitcher/win32"
+	"time"
+)
+
+// metrics are debugging counters, served by the optional metrics endpoint
+var metrics struct {
+	// Windows whose details (process, icon) were reused vs. looked up
+	detailHits   atomic.Uint64
+	detailMisses atomic.Uint64
+
+	lastEnumeration atomic.Int64 // time.Duration
+	maxEnumeration  atomic.Int64 // time.Duration
+	hookInstalled   atomic.Bool
+}
+
+type MetricsSnapshot struct {
+	TrackedWindows        int     `json:"trackedWindows"`
+	DetailCacheHits       uint64  `json:"detailCacheHits"`
+	DetailCacheMisses     uint64  `json:"detailCacheMisses"`
+	DetailCacheHitRate    float64 `json:"detailCacheHitRate"`
+	LastEnumerationMs     float64 `json:"lastEnumerationMs"`
+	MaxEnumerationMs      float64 `json:"maxEnumerationMs"`
+	KeyboardHookInstalled bool    `json:"keyboardHookInstalled"`
+	// Icons and bitmaps created but not freed yet, which should stay flat
+	// over time
+	OutstandingIcons   int64 `json:"outstandingIcons"`
+	OutstandingBitmaps int64 `json:"outstandingBitmaps"`
+}
+
+func metricsSnapshot() MetricsSnapshot {
+	hits, misses := metrics.detailHits.Load(), metrics.detailMisses.Load()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+
+	handles := win32.GetHandleCounts()
+	return MetricsSnapshot{
+		TrackedWindows:        len(Snapshot()),
+		DetailCacheHits:       hits,
+		DetailCacheMisses:     misses,
+		DetailCacheHitRate:    hitRate,
+		LastEnumerationMs:     durationMs(time.Duration(metrics.lastEnumeration.Load())),
+		MaxEnumerationMs:      durationMs(time.Duration(metrics.maxEnumeration.Load())),
+		KeyboardHookInstalled: metrics.hookInstalled.Load(),
+		OutstandingIcons:      handles.OutstandingIcons(),
+		OutstandingBitmaps:    handles.OutstandingBitmaps(),
+	}
+}
+
+const (
+	// slowEnumerationThreshold is when a GetAltTabWindows pass starts to
+	// overlap with the next poll
+	slowEnumerationThreshold = time.Second
+	// slowWindowThreshold marks a window as a culprit of a slow pass
+	slowWindowThreshold = 100 * time.Millisecond
+)
+
+// windowTiming is how long one window took to answer for its icon
+type windowTiming struct {
+	caption  string
+	exePath  string
+	duration time.Duration
+}
+
+// recordEnumeration updates the enumeration metrics and, for slow passes,
+// logs which windows were slow to hand over their icon
+func recordEnumeration(elapsed time.Duration, iconTimes []windowTiming) {
+	metrics.lastEnumeration.Store(int64(elapsed))
+	for {
+		prev := metrics.maxEnumeration.Load()
+		if int64(elapsed) <= prev || metrics.maxEnumeration.CompareAndSwap(prev, int64(elapsed)) {
+			break
+		}
+	}
+
+	if elapsed < slowEnumerationThreshold {
+		return
+	}
+
+	slices.SortFunc(iconTimes, func(a, b windowTiming) int {
+		return cmp.Compare(b.duration, a.duration)
+	})
+	log.Printf("Window enumeration took %v (threshold %v)", elapsed, slowEnumerationThreshold)
+	for _, timing := range iconTimes {
+		if timing.duration < slowWindowThreshold {
+			break
+		}
+		log.Printf("  slow icon lookup: %v for %q (%s)", timing.duration, timing.caption, timing.exePath)
+	}
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// startMetricsServer serves /health and /metrics on localhost only. It's meant
+// for debugging and is off unless MetricsPort is set in the config.
+func startMetricsServer(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metricsSnapshot())
+	})
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	go func() {
+		log.Printf("Serving metrics on http://%s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+}
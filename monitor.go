@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"tabswitcher/win32"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/sys/windows"
+)
+
+// switcherWindow is the switcher's own webview window, set up in main
+var switcherWindow *application.WebviewWindow
+
+// switcherHwnd returns the native handle of the switcher window
+func switcherHwnd() (windows.HWND, error) {
+	if switcherWindow == nil {
+		return 0, errors.New("switcher window not created yet")
+	}
+	handle := switcherWindow.NativeWindow()
+	if handle == nil {
+		return 0, errors.New("switcher window has no native window yet")
+	}
+	return windows.HWND(handle), nil
+}
+
+const (
+	// PositionActiveMonitor centers the switcher on the monitor the
+	// foreground window is on
+	PositionActiveMonitor = "activeMonitor"
+	// PositionCursor puts the switcher's top-left corner at the mouse cursor
+	PositionCursor = "cursor"
+	// PositionFixed leaves the switcher wherever it was last
+	PositionFixed = "fixed"
+)
+
+// positionSwitcher moves hwnd according to config.SwitcherPosition. Unknown
+// modes fall back to PositionActiveMonitor.
+func positionSwitcher(hwnd windows.HWND) error {
+	switch config.SwitcherPosition {
+	case PositionFixed:
+		return nil
+	case PositionCursor:
+		return placeAtCursor(hwnd)
+	default:
+		return centerOnActiveMonitor(hwnd)
+	}
+}
+
+// dpiScale returns hwnd's current DPI and the one of monitor, with ok false
+// when they're the same or can't be told apart. GetDpiForWindow returns 0
+// before Windows 10 1607, where there's no per-monitor scaling to account for.
+func dpiScale(hwnd windows.HWND, monitor win32.HANDLE) (current, target uint32, ok bool) {
+	current = win32.GetDpiForWindow(hwnd)
+	target, _, err := win32.GetDpiForMonitor(monitor)
+	if err != nil || current == 0 || target == current {
+		return 0, 0, false
+	}
+	return current, target, true
+}
+
+// sizeOnMonitor returns the size hwnd will have on monitor. Sizes are in
+// physical pixels, and a window moved to a monitor with a different scale
+// factor gets resized by Windows afterwards, so placing it by its current size
+// would be off on mixed-DPI setups.
+func sizeOnMonitor(hwnd windows.HWND, monitor win32.HANDLE) (int32, int32, error) {
+	var rect win32.RECT
+	if err := win32.GetWindowRect(hwnd, &rect); err != nil {
+		return 0, 0, fmt.Errorf("GetWindowRect failed: %w", err)
+	}
+	width, height := rect.Right-rect.Left, rect.Bottom-rect.Top
+
+	if current, target, ok := dpiScale(hwnd, monitor); ok {
+		width = width * int32(target) / int32(current)
+		height = height * int32(target) / int32(current)
+	}
+	return width, height, nil
+}
+
+// moveToMonitor places hwnd at x, y, kept within the monitor's work area so
+// that with its size there, width by height, it never straddles an edge. When
+// the move changes hwnd's DPI, only its position is set: the WM_DPICHANGED
+// that follows resizes it, and setting the scaled size as well would have it
+// scaled twice.
+func moveToMonitor(hwnd windows.HWND, monitor win32.HANDLE, x, y, width, height int32) error {
+	var info win32.MONITORINFO
+	if err := win32.GetMonitorInfoW(monitor, &info); err != nil {
+		return fmt.Errorf("GetMonitorInfoW failed: %w", err)
+	}
+	rect := win32.ClampRect(win32.RECT{Left: x, Top: y, Right: x + width, Bottom: y + height}, info.RcWork)
+
+	flags := uint32(win32.SWP_NOZORDER | win32.SWP_NOACTIVATE)
+	if _, _, ok := dpiScale(hwnd, monitor); ok {
+		flags |= win32.SWP_NOSIZE
+	}
+	err := win32.SetWindowPos(hwnd, 0, rect.Left, rect.Top, rect.Right-rect.Left, rect.Bottom-rect.Top, flags)
+	if err != nil {
+		return fmt.Errorf("SetWindowPos failed: %w", err)
+	}
+	return nil
+}
+
+// centerOnActiveMonitor moves hwnd to the center of the work area of the
+// monitor the foreground window is on
+func centerOnActiveMonitor(hwnd windows.HWND) error {
+	foreground := win32.GetForegroundWindow()
+	if foreground == 0 {
+		foreground = hwnd
+	}
+	monitor := win32.MonitorFromWindow(foreground, win32.MONITOR_DEFAULTTONEAREST)
+
+	var info win32.MONITORINFO
+	if err := win32.GetMonitorInfoW(monitor, &info); err != nil {
+		return fmt.Errorf("GetMonitorInfoW failed: %w", err)
+	}
+	width, height, err := sizeOnMonitor(hwnd, monitor)
+	if err != nil {
+		return err
+	}
+
+	work := info.RcWork
+	x := work.Left + (work.Right-work.Left-width)/2
+	y := work.Top + (work.Bottom-work.Top-height)/2
+	return moveToMonitor(hwnd, monitor, x, y, width, height)
+}
+
+// placeAtCursor moves hwnd's top-left corner to the mouse cursor, pushed back
+// onto the cursor's monitor where it would run off it
+func placeAtCursor(hwnd windows.HWND) error {
+	var pt win32.POINT
+	if err := win32.GetCursorPos(&pt); err != nil {
+		return fmt.Errorf("GetCursorPos failed: %w", err)
+	}
+	at := win32.RECT{Left: pt.X, Top: pt.Y, Right: pt.X + 1, Bottom: pt.Y + 1}
+	monitor := win32.MonitorFromRect(&at, win32.MONITOR_DEFAULTTONEAREST)
+
+	width, height, err := sizeOnMonitor(hwnd, monitor)
+	if err != nil {
+		return err
+	}
+	return moveToMonitor(hwnd, monitor, pt.X, pt.Y, width, height)
+}
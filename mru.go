@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// savedMRU holds the LastActive values saved by the previous session, keyed
+// by placementKey. They're handed out to the windows found by the first
+// enumeration pass and dropped afterwards.
+var savedMRU = struct {
+	sync.Mutex
+	byKey map[string]int
+}{}
+
+func mruPath() (string, error) {
+	return dataFilePath("mru.json")
+}
+
+// loadMRU reads the MRU order saved by saveMRU. A missing file is not an
+// error, the windows just start out in Z-order.
+func loadMRU() error {
+	path, err := mruPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	byKey := map[string]int{}
+	if err := json.Unmarshal(data, &byKey); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	savedMRU.Lock()
+	savedMRU.byKey = byKey
+	savedMRU.Unlock()
+	return nil
+}
+
+// saveMRU writes the LastActive value of every window that was activated, so
+// the next session can pick up the same order
+func saveMRU() error {
+	byKey := map[string]int{}
+	for _, window := range Snapshot() {
+		if window.LastActive == 0 {
+			continue
+		}
+		key := placementKey(window)
+		byKey[key] = max(byKey[key], window.LastActive)
+	}
+
+	path, err := mruPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(byKey, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// restoreLastActive returns the saved LastActive value for window, or 0 for
+// windows the previous session didn't know about
+func restoreLastActive(window UserWindow) int {
+	savedMRU.Lock()
+	defer savedMRU.Unlock()
+	return savedMRU.byKey[placementKey(window)]
+}
+
+// forgetSavedMRU drops the saved order once the windows that were open at
+// startup have been matched against it
+func forgetSavedMRU() {
+	savedMRU.Lock()
+	savedMRU.byKey = nil
+	savedMRU.Unlock()
+}
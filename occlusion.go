@@ -0,0 +1,144 @@
+package main
+
+import (
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// OcclusionFilterOccluded lists only windows that are mostly covered by
+	// others, see occludedBelow
+	OcclusionFilterOccluded = "occluded"
+	// OcclusionFilterHidden lists only windows that can't be seen at all
+	OcclusionFilterHidden = "hidden"
+)
+
+// occludedBelow is the VisibleFraction under which a window counts as
+// occluded
+const occludedBelow = 0.5
+
+// zOrderRect is the bounds of a window that can cover the ones below it
+type zOrderRect struct {
+	hwnd   windows.HWND
+	bounds win32.RECT
+}
+
+// zOrderRects returns the bounds of the windows among hwnds, which are in
+// Z-order, that can hide the windows below them: the visible ones that
+// aren't cloaked or minimized. Click-through overlays (WS_EX_TRANSPARENT),
+// such as screen dimmers, are left out as they're usually see-through too, and
+// so is the switcher, which only covers the others while it's up.
+func zOrderRects(hwnds []windows.HWND) []zOrderRect {
+	own, _ := switcherHwnd()
+	var rects []zOrderRect
+	for _, hwnd := range hwnds {
+		if hwnd == own {
+			continue
+		}
+		if !windowProvider.IsWindowVisible(hwnd) || windowProvider.Cloaked(hwnd) != 0 || windowProvider.IsIconic(hwnd) {
+			continue
+		}
+		if windowProvider.ExStyle(hwnd)&win32.WS_EX_TRANSPARENT != 0 {
+			continue
+		}
+		bounds, err := windowProvider.Bounds(hwnd)
+		if err != nil {
+			continue
+		}
+		rects = append(rects, zOrderRect{hwnd, bounds})
+	}
+	return rects
+}
+
+// visibleFraction returns how much of hwnd isn't covered by the windows above
+// it in zOrder, from 0 for fully hidden (or minimized) to 1. It's
+// approximate: windows are taken as opaque rectangles, so rounded corners,
+// shadows and translucent windows count as covering, and the part of hwnd
+// that's off-screen counts as visible.
+func visibleFraction(hwnd windows.HWND, zOrder []zOrderRect) float64 {
+	index := -1
+	for i, rect := range zOrder {
+		if rect.hwnd == hwnd {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		// Minimized, or its bounds couldn't be read
+		return 0
+	}
+
+	bounds := zOrder[index].bounds
+	total := rectArea(bounds)
+	if total == 0 {
+		return 0
+	}
+	uncovered := []win32.RECT{bounds}
+	for _, above := range zOrder[:index] {
+		var next []win32.RECT
+		for _, piece := range uncovered {
+			next = append(next, subtractRect(piece, above.bounds)...)
+		}
+		uncovered = next
+		if len(uncovered) == 0 {
+			return 0
+		}
+	}
+
+	var visible int64
+	for _, piece := range uncovered {
+		visible += rectArea(piece)
+	}
+	return float64(visible) / float64(total)
+}
+
+// subtractRect returns the parts of rect outside cut, as up to four
+// non-overlapping rectangles
+func subtractRect(rect, cut win32.RECT) []win32.RECT {
+	overlap, ok := win32.IntersectRects(rect, cut)
+	if !ok {
+		return []win32.RECT{rect}
+	}
+
+	var pieces []win32.RECT
+	if overlap.Top > rect.Top {
+		pieces = append(pieces, win32.RECT{Left: rect.Left, Top: rect.Top, Right: rect.Right, Bottom: overlap.Top})
+	}
+	if overlap.Bottom < rect.Bottom {
+		pieces = append(pieces, win32.RECT{Left: rect.Left, Top: overlap.Bottom, Right: rect.Right, Bottom: rect.Bottom})
+	}
+	if overlap.Left > rect.Left {
+		pieces = append(pieces, win32.RECT{Left: rect.Left, Top: overlap.Top, Right: overlap.Left, Bottom: overlap.Bottom})
+	}
+	if overlap.Right < rect.Right {
+		pieces = append(pieces, win32.RECT{Left: overlap.Right, Top: overlap.Top, Right: rect.Right, Bottom: overlap.Bottom})
+	}
+	return pieces
+}
+
+func rectArea(rect win32.RECT) int64 {
+	return int64(max(rect.Right-rect.Left, 0)) * int64(max(rect.Bottom-rect.Top, 0))
+}
+
+// filterOcclusion keeps the windows matching filter, one of the
+// OcclusionFilter* values. Any other filter keeps them all.
+func filterOcclusion(list []UserWindow, filter string) []UserWindow {
+	var keep func(UserWindow) bool
+	switch filter {
+	case OcclusionFilterOccluded:
+		keep = func(window UserWindow) bool { return window.IsOccluded }
+	case OcclusionFilterHidden:
+		keep = func(window UserWindow) bool { return window.VisibleFraction == 0 }
+	default:
+		return list
+	}
+
+	filtered := list[:0]
+	for _, window := range list {
+		if keep(window) {
+			filtered = append(filtered, window)
+		}
+	}
+	return filtered
+}
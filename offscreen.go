@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// OffScreenMove lists off-screen windows flagged with UserWindow.OffScreen,
+	// so the frontend can offer SwitcherService.MoveOnScreen
+	OffScreenMove = "move"
+	// OffScreenHide leaves off-screen windows out of the list
+	OffScreenHide = "hide"
+)
+
+// offScreen reports whether none of hwnd lies within areas, the monitors'
+// work areas. That's usually a window left behind on a display that was
+// disconnected. Minimized windows are parked off-screen by Windows and don't
+// count, and neither does anything when the monitors couldn't be read.
+func offScreen(hwnd windows.HWND, areas []win32.RECT) bool {
+	if len(areas) == 0 || windowProvider.IsIconic(hwnd) {
+		return false
+	}
+	bounds, err := windowProvider.Bounds(hwnd)
+	if err != nil {
+		return false
+	}
+	for _, area := range areas {
+		if _, ok := win32.IntersectRects(bounds, area); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// skipOffScreen reports whether an off-screen window is left out of the list.
+// Untitled ones always are: some apps park visible helper windows off-screen
+// on purpose, and those are neither worth switching to nor moving into view.
+func skipOffScreen(caption string) bool {
+	return caption == "" || config.OffScreenWindows == OffScreenHide
+}
+
+// moveOnScreen moves hwnd into the work area of the monitor nearest to it,
+// keeping its size where it fits
+func moveOnScreen(hwnd windows.HWND) error {
+	var rect win32.RECT
+	if err := win32.GetWindowRect(hwnd, &rect); err != nil {
+		return fmt.Errorf("GetWindowRect failed: %w", err)
+	}
+	monitor := win32.MonitorFromRect(&rect, win32.MONITOR_DEFAULTTONEAREST)
+	return moveToMonitor(hwnd, monitor, rect.Left, rect.Top, rect.Right-rect.Left, rect.Bottom-rect.Top)
+}
@@ -0,0 +1,23 @@
+package main
+
+// overlayIconSource looks up the taskbar overlay icon of a window, returning
+// it as a PNG data URL and whether it found one
+type overlayIconSource func(window UserWindow) (string, bool)
+
+// overlayIconSources are tried in order for UserWindow.OverlayIcon. Apps set
+// their overlay (e.g. an unread count badge) with
+// ITaskbarList3::SetOverlayIcon, but Windows offers no way to read another
+// app's overlay back, so there are none yet. App-specific sources, such as
+// one reading a badge from the window's UI Automation tree, go here.
+var overlayIconSources []overlayIconSource
+
+// overlayIcon returns the first overlay icon the sources find for window,
+// or "" for none
+func overlayIcon(window UserWindow) string {
+	for _, source := range overlayIconSources {
+		if icon, ok := source(window); ok {
+			return icon
+		}
+	}
+	return ""
+}
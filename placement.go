@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+// savedPlacements maps a window identity (see placementKey) to its saved
+// placement. They're persisted to placements.json in the settings directory.
+var savedPlacements = struct {
+	sync.Mutex
+	loaded bool
+	byKey  map[string]win32.WINDOWPLACEMENT
+}{}
+
+// placementKey identifies a window across restarts by its app and title,
+// since HWNDs and session IDs don't survive them
+func placementKey(window UserWindow) string {
+	return appKey(window) + "|" + window.Caption
+}
+
+func placementsPath() (string, error) {
+	return dataFilePath("placements.json")
+}
+
+// loadPlacements reads the saved placements on first use. Callers must hold
+// savedPlacements' lock.
+func loadPlacements() error {
+	if savedPlacements.loaded {
+		return nil
+	}
+	savedPlacements.byKey = map[string]win32.WINDOWPLACEMENT{}
+
+	path, err := placementsPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		savedPlacements.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &savedPlacements.byKey); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	savedPlacements.loaded = true
+	return nil
+}
+
+// storePlacements writes the saved placements to disk. Callers must hold
+// savedPlacements' lock.
+func storePlacements() error {
+	path, err := placementsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(savedPlacements.byKey, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// saveWindowPlacement remembers where hwnd is and how it's shown
+func saveWindowPlacement(hwnd windows.HWND) error {
+	window, ok := FindWindow(hwnd)
+	if !ok {
+		return fmt.Errorf("window %v is not tracked", hwnd)
+	}
+
+	var placement win32.WINDOWPLACEMENT
+	if err := win32.GetWindowPlacement(hwnd, &placement); err != nil {
+		return fmt.Errorf("GetWindowPlacement failed: %w", err)
+	}
+
+	savedPlacements.Lock()
+	defer savedPlacements.Unlock()
+	if err := loadPlacements(); err != nil {
+		return err
+	}
+	savedPlacements.byKey[placementKey(window)] = placement
+	return storePlacements()
+}
+
+// restoreWindowPlacement moves a window back to its saved placement. The
+// restored bounds are clamped to the nearest monitor's work area, in case the
+// monitor it was saved on has since been disconnected or rearranged.
+func restoreWindowPlacement(window UserWindow) error {
+	savedPlacements.Lock()
+	err := loadPlacements()
+	placement, ok := savedPlacements.byKey[placementKey(window)]
+	savedPlacements.Unlock()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no saved placement for %q", window.Caption)
+	}
+
+	monitor := win32.MonitorFromRect(&placement.RcNormalPosition, win32.MONITOR_DEFAULTTONEAREST)
+	var info win32.MONITORINFO
+	if err := win32.GetMonitorInfoW(monitor, &info); err == nil {
+		placement.RcNormalPosition = win32.ClampRect(placement.RcNormalPosition, info.RcWork)
+	}
+
+	if err := win32.SetWindowPlacement(window.Hwnd, &placement); err != nil {
+		return fmt.Errorf("SetWindowPlacement failed: %w", err)
+	}
+	return nil
+}
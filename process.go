@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+type processInfo struct {
+	pid     uint32
+	exePath string
+	// startTime is the process creation time in 100ns ticks since 1601,
+	// which together with pid identifies a process even after pid reuse
+	startTime int64
+	// elevated is set when the process runs with an elevated token
+	elevated bool
+	// integrity is the integrity level of the process's token, one of the
+	// win32.SECURITY_MANDATORY_*_RID values, valid when integrityKnown is set
+	integrity      uint32
+	integrityKnown bool
+}
+
+// processKey identifies a process for the lifetime of the session. Windows
+// reuses PIDs, so the PID alone can end up pointing at an unrelated process.
+type processKey struct {
+	pid       uint32
+	startTime int64
+}
+
+func (p processInfo) key() processKey {
+	return processKey{p.pid, p.startTime}
+}
+
+// filetimeTicks turns a FILETIME into 100ns ticks since 1601
+func filetimeTicks(ft windows.Filetime) int64 {
+	return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+}
+
+// processCreationTime returns the creation time of an open process handle
+func processCreationTime(hProcess windows.Handle) (int64, error) {
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(hProcess, &creation, &exit, &kernel, &user); err != nil {
+		return 0, err
+	}
+	return filetimeTicks(creation), nil
+}
+
+// processStartTime returns the creation time of the process pid, for telling
+// a process apart from a later one that got the same PID
+func processStartTime(pid uint32) (int64, error) {
+	hProcess, err := windows.OpenProcess(win32.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return 0, fmt.Errorf("OpenProcess failed: %w", err)
+	}
+	defer windows.CloseHandle(hProcess)
+	return processCreationTime(hProcess)
+}
+
+// processKeyOf returns the processKey of pid. Processes whose start time
+// can't be read (e.g. protected ones) get a zero start time, which is also
+// what getWindowProcess records for them, so they still compare equal.
+func processKeyOf(pid uint32) processKey {
+	startTime, _ := processStartTime(pid)
+	return processKey{pid, startTime}
+}
+
+// getWindowProcess looks up the process owning hwnd. Fields it can't read
+// (e.g. for elevated processes) are left empty.
+func getWindowProcess(hwnd windows.HWND) processInfo {
+	var processId win32.DWORD
+	win32.GetWindowThreadProcessId(hwnd, &processId)
+	info := processInfo{pid: uint32(processId)}
+
+	hProcess, err := windows.OpenProcess(win32.PROCESS_QUERY_LIMITED_INFORMATION, false, info.pid)
+	if err != nil {
+		return info
+	}
+	defer windows.CloseHandle(hProcess)
+
+	var exePathBuf [win32.MAX_PATH]uint16
+	exePathSize := win32.DWORD(win32.MAX_PATH)
+	err = win32.QueryFullProcessImageNameW(hProcess, 0, &exePathBuf[0], &exePathSize)
+	if err == nil {
+		info.exePath = windows.UTF16ToString(exePathBuf[:])
+	}
+
+	if startTime, err := processCreationTime(hProcess); err == nil {
+		info.startTime = startTime
+	}
+
+	var token windows.Token
+	if windows.OpenProcessToken(hProcess, windows.TOKEN_QUERY, &token) == nil {
+		info.elevated = token.IsElevated()
+		if level, err := win32.GetTokenIntegrityLevel(token); err == nil {
+			info.integrity, info.integrityKnown = level, true
+		}
+		token.Close()
+	}
+
+	return info
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestReusedPidIsNewProcess(t *testing.T) {
+	old := processKey{pid: 42, startTime: 133_000_000_000_000_000}
+	reused := processKey{pid: 42, startTime: 133_000_000_600_000_000}
+
+	window := UserWindow{ProcessID: 42, processStart: old.startTime}
+	if window.process() != old {
+		t.Fatalf("window.process() = %+v, want %+v", window.process(), old)
+	}
+	if window.process() == reused {
+		t.Error("a window of the old process belongs to the one that reused its PID")
+	}
+
+	// A cycle through the old process's windows doesn't carry over
+	var cycle processCycle
+	if hwnd, _ := cycle.step(old, []UserWindow{{Hwnd: 1}, {Hwnd: 2}, {Hwnd: 3}}); hwnd != 2 {
+		t.Fatalf("first step went to %v, want 2", hwnd)
+	}
+	hwnd, ok := cycle.step(reused, []UserWindow{{Hwnd: 7}, {Hwnd: 8}})
+	if !ok || hwnd != 8 {
+		t.Errorf("step for the new process went to %v, %v, want 8, true", hwnd, ok)
+	}
+}
+
+func TestFiletimeTicks(t *testing.T) {
+	ft := windows.Filetime{HighDateTime: 0x01DA0000, LowDateTime: 0xFFFFFFFF}
+	if got, want := filetimeTicks(ft), int64(0x01DA0000FFFFFFFF); got != want {
+		t.Errorf("filetimeTicks = %#x, want %#x", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"runtime"
+	"tabswitcher/win32"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// InputModeHook watches all keyboard input with a low-level keyboard hook.
+	// It sees every key, so any chord works and the keys still reach the
+	// focused app, but Windows drops the hook when it's slow to respond (see
+	// keyboardHook's watchdog) and it adds a little latency to all typing.
+	InputModeHook = "hook"
+	// InputModeHotkey registers the chords with RegisterHotKey, and Windows
+	// posts a WM_HOTKEY when one is pressed. Nothing can drop it, but only the
+	// configured chords are seen and they no longer reach the focused app.
+	// Chords already registered by Windows or another app can't be used.
+	// Releasing Alt and pressing Escape are polled for while the switcher is
+	// shown (see pollSwitcherKeys).
+	InputModeHotkey = "hotkey"
+
+	// switcherKeyPollInterval is how often InputModeHotkey checks for Alt
+	// being released and Escape being pressed while the switcher is shown
+	switcherKeyPollInterval = 15 * time.Millisecond
+)
+
+// hotkeyFlags returns the RegisterHotKey modifier flags for m
+func (m Modifiers) hotkeyFlags() uint32 {
+	var flags uint32
+	if m.Alt {
+		flags |= win32.MOD_ALT
+	}
+	if m.Ctrl {
+		flags |= win32.MOD_CONTROL
+	}
+	if m.Shift {
+		flags |= win32.MOD_SHIFT
+	}
+	if m.Win {
+		flags |= win32.MOD_WIN
+	}
+	return flags
+}
+
+// startRegisteredHotkeys registers chords as system hotkeys and calls onAction
+// with the chord's action whenever one is pressed. The hotkeys belong to a
+// dedicated thread that pumps their WM_HOTKEY messages. Chords that fail to
+// register are logged and skipped, it's only an error if none registered.
+func startRegisteredHotkeys(chords []chord, onAction func(action string)) error {
+	registered := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		// WM_HOTKEY carries the ID the hotkey was registered with
+		actions := make(map[int32]string)
+		defer func() {
+			for id := range actions {
+				win32.UnregisterHotKey(0, id)
+			}
+		}()
+		for i, c := range chords {
+			id := int32(i + 1)
+			if err := win32.RegisterHotKey(0, id, c.mods.hotkeyFlags(), c.vk); err != nil {
+				log.Printf("Failed to register the hotkey for %q: %v", c.action, err)
+				continue
+			}
+			actions[id] = c.action
+		}
+		if len(actions) == 0 {
+			registered <- errors.New("no hotkey could be registered")
+			return
+		}
+		registered <- nil
+		log.Printf("Registered %d of %d hotkeys", len(actions), len(chords))
+
+		msg := &win32.MSG{}
+		for {
+			if ret, err := win32.GetMessage(msg, 0, 0, 0); ret <= 0 || err != nil {
+				break
+			}
+			if msg.Message == win32.WM_HOTKEY {
+				if action, ok := actions[int32(msg.WParam)]; ok {
+					onAction(action)
+				}
+				continue
+			}
+
+			win32.TranslateMessage(msg)
+			win32.DispatchMessage(msg)
+		}
+	}()
+	return <-registered
+}
+
+// pollSwitcherKeys stands in for the keyboard hook's handling of Alt and
+// Escape in InputModeHotkey, where only the registered chords are seen. While
+// the switcher is shown it polls the keys, calling onAction with
+// ActionAltReleased once Alt was held and let go, and with ActionEscape when
+// Escape goes down. Escape isn't swallowed, so the focused app sees it too.
+func pollSwitcherKeys(onAction func(action string)) {
+	var altHeld, escapeHeld bool
+	for range time.Tick(switcherKeyPollInterval) {
+		if !switcherVisible.Load() {
+			altHeld, escapeHeld = false, false
+			continue
+		}
+
+		alt := win32.GetAsyncKeyState(windows.VK_MENU)
+		if altHeld && !alt {
+			onAction(ActionAltReleased)
+		}
+		escape := win32.GetAsyncKeyState(windows.VK_ESCAPE)
+		if escape && !escapeHeld {
+			onAction(ActionEscape)
+		}
+		altHeld, escapeHeld = alt, escape
+	}
+}
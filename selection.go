@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/sys/windows"
+)
+
+// selection is the switcher's current position in the window list, for
+// frontends that leave navigation to the Go side
+var selection struct {
+	mu    sync.Mutex
+	index int
+}
+
+// selectedWindow returns the window at the current selection index of the
+// window list, clamping the index when the list has shrunk. The list is the
+// one of the last enumeration pass, which keeps a full enumeration from
+// running with selection.mu held.
+func selectedWindow() (UserWindow, bool) {
+	windows := shapeWindows(listedWindows(), config.SwitchMode)
+	if len(windows) == 0 {
+		return UserWindow{}, false
+	}
+
+	selection.index = ((selection.index % len(windows)) + len(windows)) % len(windows)
+	return windows[selection.index], true
+}
+
+// moveSelection moves the selection by delta (wrapping around) and emits
+// selectionChanged with the newly selected window
+func moveSelection(app *application.App, delta int) windows.HWND {
+	selection.mu.Lock()
+	defer selection.mu.Unlock()
+
+	selection.index += delta
+	window, ok := selectedWindow()
+	if !ok {
+		return 0
+	}
+
+	app.Event.Emit("selectionChanged", formatHwnd(window.Hwnd))
+	return window.Hwnd
+}
+
+// resetSelection selects the previously active window, which is what a
+// switcher that was just shown should offer first
+func resetSelection(app *application.App) windows.HWND {
+	selection.mu.Lock()
+	selection.index = 0
+	selection.mu.Unlock()
+
+	return moveSelection(app, 1)
+}
+
+// commitSelection activates the selected window
+func commitSelection(app *application.App) error {
+	selection.mu.Lock()
+	window, ok := selectedWindow()
+	selection.mu.Unlock()
+
+	if !ok {
+		return errors.New("no window is selected")
+	}
+	return activateWindow(app, window.Hwnd)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestSelectedWindowUsesLastPass(t *testing.T) {
+	resetUserWindows(t)
+	useConfig(t, func(c *Config) {
+		c.SwitchMode = SwitchModeWindows
+		c.SortMode = SortModeZOrder
+		c.MergeDuplicateWindows = false
+		c.OcclusionFilter = ""
+		c.MaxWindows = 0
+		c.ShowDesktopEntry = false
+	})
+	selection.mu.Lock()
+	defer selection.mu.Unlock()
+
+	applyPass(appliedPass+1, []UserWindow{{ID: 1, Hwnd: 10}, {ID: 2, Hwnd: 20}, {ID: 3, Hwnd: 30}})
+
+	tests := []struct {
+		index int
+		want  windows.HWND
+	}{
+		{1, 20},
+		// Wrapping around either end
+		{3, 10},
+		{-1, 30},
+	}
+	for _, tt := range tests {
+		selection.index = tt.index
+		window, ok := selectedWindow()
+		if !ok || window.Hwnd != tt.want {
+			t.Errorf("selectedWindow() at index %d = %v, %v, want %v", tt.index, window.Hwnd, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"syscall"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+// attentionWindows holds the windows that flashed their taskbar button and
+// haven't been activated since
+var attentionWindows sync.Map
+
+func needsAttention(hwnd windows.HWND) bool {
+	_, ok := attentionWindows.Load(hwnd)
+	return ok
+}
+
+// startShellHook registers a hidden window for shell hook notifications, which
+// is how the taskbar itself learns that a window is flashing. It runs its own
+// message loop on a dedicated OS thread.
+func startShellHook() {
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		hwnd, shellHookMsg, err := createShellHookWindow()
+		if err != nil {
+			log.Printf("Failed to set up shell hook, attention state won't be tracked: %v", err)
+			return
+		}
+		defer win32.DestroyWindow(hwnd)
+		defer win32.DeregisterShellHookWindow(hwnd)
+		log.Println("Shell hook installed")
+
+		msg := &win32.MSG{}
+		for {
+			if ret, err := win32.GetMessage(msg, 0, 0, 0); ret <= 0 || err != nil {
+				break
+			}
+			if msg.Message == shellHookMsg {
+				handleShellHookMessage(msg.WParam, windows.HWND(msg.LParam))
+				continue
+			}
+
+			win32.TranslateMessage(msg)
+			win32.DispatchMessage(msg)
+		}
+	}()
+}
+
+func createShellHookWindow() (windows.HWND, uint32, error) {
+	className, _ := windows.UTF16PtrFromString("TabSwitcherShellHook")
+	hInstance := win32.GetModuleHandleW(nil)
+
+	wndProc := win32.WNDPROC(func(hwnd windows.HWND, msg uint32, wParam win32.WPARAM, lParam win32.LPARAM) (ret win32.LRESULT) {
+		defer func() {
+			if r := recover(); r != nil {
+				win32.LogCallbackPanic("shell hook window", r)
+				ret = 0
+			}
+		}()
+		return win32.DefWindowProcW(hwnd, msg, wParam, lParam)
+	})
+	_, err := win32.RegisterClassExW(&win32.WNDCLASSEXW{
+		LpfnWndProc:   syscall.NewCallback(wndProc),
+		HInstance:     hInstance,
+		LpszClassName: className,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// A plain hidden top-level window, message-only windows don't get shell
+	// hook notifications
+	hwnd, err := win32.CreateWindowExW(0, className, className, 0, 0, 0, 0, 0, 0, 0, hInstance, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	shellHookName, _ := windows.UTF16PtrFromString("SHELLHOOK")
+	shellHookMsg, err := win32.RegisterWindowMessageW(shellHookName)
+	if err != nil {
+		win32.DestroyWindow(hwnd)
+		return 0, 0, err
+	}
+
+	if err := win32.RegisterShellHookWindow(hwnd); err != nil {
+		win32.DestroyWindow(hwnd)
+		return 0, 0, err
+	}
+
+	return hwnd, shellHookMsg, nil
+}
+
+func handleShellHookMessage(code uintptr, hwnd windows.HWND) {
+	switch code {
+	case win32.HSHELL_FLASH:
+		attentionWindows.Store(hwnd, struct{}{})
+	case win32.HSHELL_WINDOWACTIVATED, win32.HSHELL_RUDEAPPACTIVATED:
+		attentionWindows.Delete(hwnd)
+		// Activations made outside the switcher count for the MRU order too
+		if hwnd != 0 {
+			markActive(hwnd)
+		}
+	case win32.HSHELL_WINDOWDESTROYED:
+		attentionWindows.Delete(hwnd)
+	}
+}
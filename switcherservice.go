@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tabswitcher/win32"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/sys/windows"
+)
+
+type Modifiers struct {
+	Alt   bool
+	Ctrl  bool
+	Shift bool
+	Win   bool
+}
+
+type SwitcherService struct {
+	peekMu sync.Mutex
+	// peeked is the window raised by PeekWindow, peekAfter the window that was
+	// right above it beforehand so EndPeek can put it back
+	peeked    windows.HWND
+	peekAfter windows.HWND
+}
+
+// CurrentModifiers returns which modifier keys are held down right now, so the
+// frontend can implement modifier-aware activation (e.g. Shift+click).
+func (s *SwitcherService) CurrentModifiers() Modifiers {
+	return currentModifiers()
+}
+
+// DocumentIcon returns the file-type icon for path as a PNG data URL. It can be
+// used instead of the app icon for windows whose caption names a document.
+func (s *SwitcherService) DocumentIcon(path string) (string, error) {
+	icon, err := win32.GetFileTypeIcon(path)
+	if err != nil {
+		return "", err
+	}
+	defer win32.DestroyIcon(icon)
+
+	data, err := win32.HICONToPNG(icon)
+	if err != nil {
+		return "", err
+	}
+	return pngDataURL(data), nil
+}
+
+// WindowIcon returns the icon of the window with the given ID as PNG bytes,
+// for frontends that don't take data URLs
+func (s *SwitcherService) WindowIcon(id uint64) ([]byte, error) {
+	return GetWindowIconByID(id)
+}
+
+// WindowIconDataURL returns the icon of the window with the given ID as a PNG
+// data URL, see config.LazyIcons
+func (s *SwitcherService) WindowIconDataURL(id uint64) (string, error) {
+	data, err := GetWindowIconByID(id)
+	if err != nil {
+		return "", err
+	}
+	return pngDataURL(data), nil
+}
+
+// appKey identifies the application a window belongs to for per-app settings
+func appKey(window UserWindow) string {
+	return strings.ToLower(filepath.Base(window.ExePath))
+}
+
+// CanControlWindow reports whether close, minimize and move actions on hwnd
+// are likely to work, see canControlWindow
+func (s *SwitcherService) CanControlWindow(hwnd string) (bool, error) {
+	handle, err := parseHwnd(hwnd)
+	if err != nil {
+		return false, err
+	}
+	return canControlWindow(handle), nil
+}
+
+// AppActions returns the configured jump-list-style tasks for the app owning
+// hwnd. Windows has no public API to read another app's jump list, so these
+// come from the "appActions" section of the config file.
+func (s *SwitcherService) AppActions(hwnd string) ([]AppAction, error) {
+	handle, err := parseHwnd(hwnd)
+	if err != nil {
+		return nil, err
+	}
+	return appActions(handle), nil
+}
+
+// appActions returns the configured tasks for the app owning hwnd
+func appActions(hwnd windows.HWND) []AppAction {
+	window, ok := FindWindow(hwnd)
+	if !ok {
+		return nil
+	}
+	return config.AppActions[appKey(window)]
+}
+
+// RunAppAction launches the index-th task returned by AppActions for hwnd
+func (s *SwitcherService) RunAppAction(hwnd string, index int) error {
+	handle, err := parseHwnd(hwnd)
+	if err != nil {
+		return err
+	}
+	actions := appActions(handle)
+	if index < 0 || index >= len(actions) {
+		return fmt.Errorf("no app action %d for window %s", index, hwnd)
+	}
+	action := actions[index]
+
+	command, err := windows.UTF16PtrFromString(action.Command)
+	if err != nil {
+		return err
+	}
+	var args *uint16
+	if action.Args != "" {
+		args, err = windows.UTF16PtrFromString(action.Args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return windows.ShellExecute(0, nil, command, args, nil, windows.SW_SHOWNORMAL)
+}
+
+// CopyToClipboard puts text on the clipboard, e.g. to copy a window's title
+func (s *SwitcherService) CopyToClipboard(text string) error {
+	return win32.SetClipboardText(text)
+}
+
+// PeekWindow raises hwnd to the top of the Z-order without activating it, so
+// it can be glanced at while the current window keeps focus
+func (s *SwitcherService) PeekWindow(handle string) error {
+	hwnd, err := parseHwnd(handle)
+	if err != nil {
+		return err
+	}
+	if err := s.EndPeek(); err != nil {
+		log.Printf("Failed to restore previously peeked window: %v", err)
+	}
+
+	s.peekMu.Lock()
+	defer s.peekMu.Unlock()
+
+	above := win32.GetWindow(hwnd, win32.GW_HWNDPREV)
+	err = win32.SetWindowPos(hwnd, win32.HWND_TOP, 0, 0, 0, 0,
+		win32.SWP_NOMOVE|win32.SWP_NOSIZE|win32.SWP_NOACTIVATE)
+	if err != nil {
+		return fmt.Errorf("SetWindowPos failed: %w", err)
+	}
+
+	s.peeked = hwnd
+	s.peekAfter = above
+	return nil
+}
+
+// EndPeek puts the window raised by PeekWindow back to its old Z-order position
+func (s *SwitcherService) EndPeek() error {
+	s.peekMu.Lock()
+	defer s.peekMu.Unlock()
+
+	if s.peeked == 0 {
+		return nil
+	}
+	hwnd, after := s.peeked, s.peekAfter
+	s.peeked, s.peekAfter = 0, 0
+
+	// The window was already on top, so there is nothing to undo
+	if after == 0 {
+		return nil
+	}
+
+	return win32.SetWindowPos(hwnd, after, 0, 0, 0, 0,
+		win32.SWP_NOMOVE|win32.SWP_NOSIZE|win32.SWP_NOACTIVATE)
+}
+
+// ToggleTopmost flips hwnd's always-on-top state, returning whether it's
+// now topmost
+func (s *SwitcherService) ToggleTopmost(handle string) (bool, error) {
+	hwnd, err := parseHwnd(handle)
+	if err != nil {
+		return false, err
+	}
+	exStyle, err := win32.GetWindowLongPtrW(hwnd, win32.GWL_EXSTYLE)
+	if err != nil {
+		return false, fmt.Errorf("GetWindowLongPtrW failed: %w", err)
+	}
+
+	topmost := exStyle&win32.WS_EX_TOPMOST == 0
+	after := windows.HWND(win32.HWND_NOTOPMOST)
+	if topmost {
+		after = windows.HWND(win32.HWND_TOPMOST)
+	}
+	err = win32.SetWindowPos(hwnd, after, 0, 0, 0, 0,
+		win32.SWP_NOMOVE|win32.SWP_NOSIZE|win32.SWP_NOACTIVATE)
+	if err != nil {
+		return !topmost, fmt.Errorf("SetWindowPos failed: %w", err)
+	}
+
+	queueUserWindowsEmit(application.Get())
+	return topmost, nil
+}
+
+// MoveOnScreen moves an off-screen window (see UserWindow.OffScreen) onto
+// the monitor nearest to it
+func (s *SwitcherService) MoveOnScreen(handle string) error {
+	hwnd, err := parseHwnd(handle)
+	if err != nil {
+		return err
+	}
+	if err := moveOnScreen(hwnd); err != nil {
+		return err
+	}
+	queueUserWindowsEmit(application.Get())
+	return nil
+}
+
+// ShowOnActiveMonitor centers the switcher on the monitor the foreground
+// window is on and shows it, instead of the monitor it was first created on
+func (s *SwitcherService) ShowOnActiveMonitor() error {
+	hwnd, err := switcherHwnd()
+	if err != nil {
+		return err
+	}
+	if err := centerOnActiveMonitor(hwnd); err != nil {
+		return err
+	}
+	switcherWindow.Show()
+	return nil
+}
+
+// IsForegroundFullscreen reports whether a full-screen app (a game, a video)
+// is in the foreground, where showing the switcher can make it flicker or
+// change display modes
+func (s *SwitcherService) IsForegroundFullscreen() bool {
+	return foregroundFullscreen()
+}
+
+// ShowSwitcher places the switcher as configured by switcherPosition and
+// shows it, resuming the window list updates
+func (s *SwitcherService) ShowSwitcher() error {
+	return showSwitcher(application.Get())
+}
+
+// HideSwitcher hides the switcher without switching windows, giving the
+// focus back to the window that had it before. The window list isn't updated
+// until the switcher is shown again.
+func (s *SwitcherService) HideSwitcher() {
+	cancelSwitcher()
+}
+
+// Windows returns the switchable windows for mode, which is SwitchModeWindows
+// or SwitchModeApps. An empty mode uses the configured default.
+func (s *SwitcherService) Windows(mode string) ([]UserWindow, error) {
+	if mode == "" {
+		mode = config.SwitchMode
+	}
+	return windowsForMode(mode)
+}
+
+// AppGroups returns the windows grouped by application, with each group's
+// AppID and window count, e.g. for "Chrome (4)" badges
+func (s *SwitcherService) AppGroups() ([]AppGroup, error) {
+	return appGroups()
+}
+
+// WindowsByDesktop returns the windows of every virtual desktop, grouped by
+// desktop. Without virtual desktop support, everything is in a single
+// "Unknown desktop" group.
+func (s *SwitcherService) WindowsByDesktop() ([]DesktopGroup, error) {
+	return GetWindowsByDesktop()
+}
+
+// StaleWindows returns the windows left alone for more than olderThanMinutes,
+// least recently active first. Windows not activated since the switcher
+// started are only included with includeNeverActive.
+func (s *SwitcherService) StaleWindows(olderThanMinutes int, includeNeverActive bool) ([]UserWindow, error) {
+	return GetStaleWindows(time.Duration(olderThanMinutes)*time.Minute, includeNeverActive)
+}
+
+// ExpandApp returns the windows of the app with the given UserWindow.AppID,
+// most recently active first, so the frontend can list them inline under the
+// app's entry
+func (s *SwitcherService) ExpandApp(appID string) []UserWindow {
+	return appWindows(appID)
+}
+
+// ActivateByID activates the window with the given UserWindow.ID
+func (s *SwitcherService) ActivateByID(id uint64) error {
+	window, ok := findWindowByID(id)
+	if !ok {
+		return fmt.Errorf("no window with ID %d", id)
+	}
+	return activateWindow(application.Get(), window.Hwnd)
+}
+
+// ActivateProcess activates the most recently active window of the process
+// matching query, e.g. "chrome.exe", returning the window activated
+func (s *SwitcherService) ActivateProcess(query string) (UserWindow, error) {
+	return activateProcess(application.Get(), query)
+}
+
+// WindowUnderCursor returns the switchable window under the mouse cursor,
+// or an error if there is none
+func (s *SwitcherService) WindowUnderCursor() (UserWindow, error) {
+	window, ok := windowUnderCursor()
+	if !ok {
+		return UserWindow{}, fmt.Errorf("no switchable window under the cursor")
+	}
+	return window, nil
+}
+
+// ActivateWindowUnderCursor activates the window under the mouse cursor and
+// returns it
+func (s *SwitcherService) ActivateWindowUnderCursor() (UserWindow, error) {
+	return activateWindowUnderCursor(application.Get())
+}
+
+// CycleWithinProcess activates the next window of the process pid (0 for the
+// foreground window's process) and returns the process's windows in Z-order.
+// Rapid repeated calls keep walking the same order.
+func (s *SwitcherService) CycleWithinProcess(pid uint32) ([]UserWindow, error) {
+	return cycleWithinProcess(application.Get(), pid)
+}
+
+// ActivateSiblingWindow activates the next (direction > 0) or previous
+// (direction < 0) window of the foreground window's process without showing
+// the switcher, and returns it
+func (s *SwitcherService) ActivateSiblingWindow(direction int) (UserWindow, error) {
+	return activateSiblingWindow(application.Get(), direction)
+}
+
+// Next selects the next window in the list and returns its Hwnd
+func (s *SwitcherService) Next() string {
+	return formatHwnd(moveSelection(application.Get(), 1))
+}
+
+// Prev selects the previous window in the list and returns its Hwnd
+func (s *SwitcherService) Prev() string {
+	return formatHwnd(moveSelection(application.Get(), -1))
+}
+
+// Commit activates the selected window
+func (s *SwitcherService) Commit() error {
+	return commitSelection(application.Get())
+}
+
+// ResetSelection selects the previously active window (index 1)
+func (s *SwitcherService) ResetSelection() string {
+	return formatHwnd(resetSelection(application.Get()))
+}
+
+// SaveWindowPlacement remembers the position, size and show state of hwnd,
+// keyed by its app and title so it can be restored after restarts
+func (s *SwitcherService) SaveWindowPlacement(hwnd string) error {
+	handle, err := parseHwnd(hwnd)
+	if err != nil {
+		return err
+	}
+	return saveWindowPlacement(handle)
+}
+
+// RestoreWindowPlacement moves the window with the given UserWindow.ID back
+// to the placement saved for its app and title
+func (s *SwitcherService) RestoreWindowPlacement(id uint64) error {
+	window, ok := findWindowByID(id)
+	if !ok {
+		return fmt.Errorf("no window with ID %d", id)
+	}
+	return restoreWindowPlacement(window)
+}
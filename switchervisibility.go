@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"tabswitcher/win32"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// ActionAltReleased is sent when Alt is released while the switcher is
+	// shown, which ends the switch
+	ActionAltReleased = "altReleased"
+	// ActionEscape is sent when Escape is pressed while the switcher is shown,
+	// which dismisses it
+	ActionEscape = "escape"
+)
+
+// switcherVisible is whether the switcher window is shown. While it's hidden,
+// the window list isn't enumerated nor sent to the frontend (see
+// queueUserWindowsEmit).
+var switcherVisible atomic.Bool
+
+// visibilityMu keeps a show and a hide from interleaving
+var visibilityMu sync.Mutex
+
+// focusBeforeShow is the window that was in the foreground when the switcher
+// was shown, for cancelSwitcher to give the focus back to. Guarded by
+// visibilityMu.
+var focusBeforeShow windows.HWND
+
+// showSwitcher resumes the window updates and shows the switcher, placed as
+// configured by switcherPosition. The list is sent first so the switcher
+// doesn't show the one from before it was hidden, and the selection starts
+// over on the previously active window.
+func showSwitcher(app *application.App) error {
+	visibilityMu.Lock()
+	defer visibilityMu.Unlock()
+
+	hwnd, err := switcherHwnd()
+	if err != nil {
+		return err
+	}
+	if err := positionSwitcher(hwnd); err != nil {
+		return err
+	}
+	if !switcherVisible.Load() {
+		if foreground := win32.GetForegroundWindow(); foreground != hwnd {
+			focusBeforeShow = foreground
+		}
+		switcherVisible.Store(true)
+		emitUserWindows(app)
+		resetSelection(app)
+	}
+	switcherWindow.Show()
+	return nil
+}
+
+// hideSwitcher hides the switcher and pauses the window updates until it's
+// shown again. The focus is left to whatever window gets activated next.
+func hideSwitcher() {
+	visibilityMu.Lock()
+	defer visibilityMu.Unlock()
+	hideLocked()
+}
+
+// cancelSwitcher hides the switcher and gives the focus back to the window
+// that had it before the switcher was shown. Nothing is refocused if that
+// window was closed in the meantime, or if a window was activated since the
+// switcher was shown (activateWindow hides it, which forgets the window).
+func cancelSwitcher() {
+	visibilityMu.Lock()
+	defer visibilityMu.Unlock()
+
+	previous := focusBeforeShow
+	hideLocked()
+	if previous == 0 || !windows.IsWindow(previous) {
+		return
+	}
+	if err := setForeground(previous); err != nil {
+		log.Printf("Failed to give the focus back to window %v: %v", previous, err)
+	}
+}
+
+// hideLocked is hideSwitcher with visibilityMu held
+func hideLocked() {
+	if switcherWindow == nil {
+		return
+	}
+	focusBeforeShow = 0
+	switcherVisible.Store(false)
+	switcherWindow.Hide()
+}
+
+// handleSystemKey shows or hides the switcher for a hotkey action, reporting
+// whether the action should still be sent to the frontend. Any hotkey brings
+// the switcher up. Releasing Alt switches to the selected window, and
+// pressing Escape puts the switcher away without switching.
+func handleSystemKey(app *application.App, action string) bool {
+	switch action {
+	case ActionAltReleased:
+		if !switcherVisible.Load() {
+			return false
+		}
+		// activateWindow hides the switcher once the switch succeeded
+		if err := commitSelection(app); err != nil {
+			log.Printf("Failed to switch to the selected window: %v", err)
+			hideSwitcher()
+		}
+	case ActionEscape:
+		if !switcherVisible.Load() {
+			return false
+		}
+		cancelSwitcher()
+	default:
+		if !switcherVisible.Load() {
+			if err := showSwitcher(app); err != nil {
+				log.Printf("Failed to show the switcher: %v", err)
+			}
+		}
+	}
+	return true
+}
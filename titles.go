@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TitleRule rewrites window titles matching Pattern, a Go regular expression,
+// with Replace, which may refer to submatches as $1 and so on
+type TitleRule struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// defaultTitleRules strip the browser name that browsers append to the title
+// of the active tab
+var defaultTitleRules = map[string][]TitleRule{
+	"chrome.exe":  {{Pattern: ` - Google Chrome$`}},
+	"msedge.exe":  {{Pattern: ` - Microsoft\x{200B}? Edge$`}},
+	"firefox.exe": {{Pattern: ` [—-] Mozilla Firefox$`}},
+	"brave.exe":   {{Pattern: ` - Brave$`}},
+	"opera.exe":   {{Pattern: ` - Opera$`}},
+	"vivaldi.exe": {{Pattern: ` - Vivaldi$`}},
+}
+
+// titlePatterns caches compiled TitleRule patterns, nil for invalid ones so
+// they're only reported once
+var titlePatterns = struct {
+	sync.Mutex
+	byPattern map[string]*regexp.Regexp
+}{byPattern: map[string]*regexp.Regexp{}}
+
+func compileTitlePattern(pattern string) *regexp.Regexp {
+	titlePatterns.Lock()
+	defer titlePatterns.Unlock()
+
+	re, ok := titlePatterns.byPattern[pattern]
+	if !ok {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Ignoring invalid title rule %q: %v", pattern, err)
+		}
+		titlePatterns.byPattern[pattern] = re
+	}
+	return re
+}
+
+// displayTitle applies the title rules configured for window's app to its
+// caption. The caption itself is kept as-is for matching and search.
+func displayTitle(window UserWindow) string {
+	title := window.Caption
+	for _, rule := range config.TitleRules[appKey(window)] {
+		if re := compileTitlePattern(rule.Pattern); re != nil {
+			title = re.ReplaceAllString(title, rule.Replace)
+		}
+	}
+
+	// A rule that ate the whole title isn't an improvement
+	if strings.TrimSpace(title) == "" {
+		return window.Caption
+	}
+	return title
+}
+
+// titleSeparators split a title into the document and app parts most apps
+// build it from, e.g. "C:\notes\todo.txt - Notepad++"
+var titleSeparators = regexp.MustCompile(` [-–—|] `)
+
+// documentPath returns the full path of the document shown in a window, as
+// far as its caption tells. Editors like Notepad++, and others when set up to,
+// put the full path in the title, often with a "*" or "•" marking unsaved
+// changes. Most apps only show the file name, for which this returns "".
+func documentPath(caption string) string {
+	for _, part := range titleSeparators.Split(caption, -1) {
+		part = strings.Trim(part, " *•●")
+		if strings.HasPrefix(part, `\\`) || filepath.VolumeName(part) != "" && filepath.IsAbs(part) {
+			return part
+		}
+	}
+	return ""
+}
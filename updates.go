@@ -0,0 +1,298 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"runtime"
+	"sync"
+	"tabswitcher/win32"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// UpdateModePoll re-enumerates the windows on a timer
+	UpdateModePoll = "poll"
+	// UpdateModeEvents re-enumerates when WinEvent hooks report a change, or
+	// when an ownerWatcher sees a window change owners, which no WinEvent
+	// reports
+	UpdateModeEvents = "events"
+	// UpdateModeHybrid uses the WinEvent hooks, plus a slow poll that catches
+	// anything the hooks missed
+	UpdateModeHybrid = "hybrid"
+)
+
+const (
+	pollInterval       = time.Second
+	reconcileInterval  = 10 * time.Second
+	ownerCheckInterval = 2 * time.Second
+)
+
+// windowWatcher notices when the window list may have changed
+type windowWatcher interface {
+	// start begins watching, calling changed from any goroutine whenever the
+	// windows should be enumerated again
+	start(changed func()) error
+}
+
+// newWindowWatcher returns the watcher for one of the UpdateMode* values.
+// Unknown modes fall back to polling.
+func newWindowWatcher(mode string) windowWatcher {
+	switch mode {
+	case UpdateModeEvents:
+		return hybridWatcher{
+			events:    &eventWatcher{},
+			reconcile: ownerWatcher{interval: ownerCheckInterval},
+		}
+	case UpdateModeHybrid:
+		return hybridWatcher{
+			events:    &eventWatcher{},
+			reconcile: pollWatcher{interval: reconcileInterval},
+		}
+	default:
+		return pollWatcher{interval: pollInterval}
+	}
+}
+
+// startWindowUpdates starts the configured watcher and emits the window list
+// whenever it reports a change
+func startWindowUpdates(app *application.App) {
+	changed := func() {
+		queueUserWindowsEmit(app)
+	}
+	if err := newWindowWatcher(config.UpdateMode).start(changed); err != nil {
+		log.Printf("Failed to watch window events, polling instead: %v", err)
+		pollWatcher{interval: pollInterval}.start(changed)
+	}
+}
+
+// windowListEmits limits how often userWindowsChanged is sent
+var windowListEmits emitThrottle
+
+// queueUserWindowsEmit emits the window list right away if none was emitted
+// in the last config.EmitIntervalMs, or otherwise once the interval is up.
+// Requests made in the meantime are coalesced into that one emission, which
+// reads the windows when it runs and so always sends the latest state.
+// Nothing is sent while the switcher is hidden, showSwitcher sends a fresh
+// list when it comes back.
+func queueUserWindowsEmit(app *application.App) {
+	if !switcherVisible.Load() {
+		return
+	}
+	interval := time.Duration(config.EmitIntervalMs) * time.Millisecond
+	windowListEmits.trigger(interval, func() {
+		emitUserWindows(app)
+	})
+}
+
+// emitThrottle runs at most one emission per interval, one at a time
+type emitThrottle struct {
+	mu sync.Mutex
+	// last is when the previous emission started
+	last time.Time
+	// scheduled is set while an emission is waiting to run
+	scheduled bool
+	// emitting serializes the emissions, so a slow one can't be overtaken
+	// and deliver an older list last
+	emitting sync.Mutex
+}
+
+func (t *emitThrottle) trigger(interval time.Duration, emit func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.scheduled {
+		return
+	}
+	t.scheduled = true
+	wait := max(interval-time.Since(t.last), 0)
+	time.AfterFunc(wait, func() {
+		t.emitting.Lock()
+		defer t.emitting.Unlock()
+
+		t.mu.Lock()
+		t.scheduled = false
+		t.last = time.Now()
+		t.mu.Unlock()
+		emit()
+	})
+}
+
+type pollWatcher struct {
+	interval time.Duration
+}
+
+func (w pollWatcher) start(changed func()) error {
+	go func() {
+		for {
+			changed()
+			<-time.After(w.interval)
+		}
+	}()
+	return nil
+}
+
+// ownerWatcher reports a change whenever the owner of a visible top-level
+// window changed since the last check. Owners are set with
+// SetWindowLongPtr(GWLP_HWNDPARENT), which sends no WinEvent, and a window
+// that gains or loses its owner can become eligible for the list or stop
+// being.
+type ownerWatcher struct {
+	interval time.Duration
+}
+
+func (w ownerWatcher) start(changed func()) error {
+	go func() {
+		owners := windowOwners()
+		for {
+			<-time.After(w.interval)
+			current := windowOwners()
+			if !maps.Equal(owners, current) {
+				changed()
+			}
+			owners = current
+		}
+	}()
+	return nil
+}
+
+// windowOwners maps the visible top-level windows to their root owner, which
+// is the window itself for unowned ones
+func windowOwners() map[windows.HWND]windows.HWND {
+	hwnds, err := windowProvider.EnumWindows()
+	if err != nil {
+		return nil
+	}
+	owners := make(map[windows.HWND]windows.HWND, len(hwnds))
+	for _, hwnd := range hwnds {
+		if windowProvider.IsWindowVisible(hwnd) {
+			owners[hwnd] = windowProvider.Ancestor(hwnd, win32.GA_ROOTOWNER)
+		}
+	}
+	return owners
+}
+
+// hybridWatcher combines the WinEvent hooks with a watcher for what they miss
+type hybridWatcher struct {
+	events    *eventWatcher
+	reconcile windowWatcher
+}
+
+func (w hybridWatcher) start(changed func()) error {
+	if err := w.events.start(changed); err != nil {
+		return err
+	}
+	return w.reconcile.start(changed)
+}
+
+// eventWatcher listens for WinEvents about top-level windows being created,
+// destroyed, shown, hidden, cloaked, retitled, reparented or activated
+type eventWatcher struct {
+	changed func()
+	// proc is created once so every hook shares the same callback
+	proc win32.WINEVENTPROC
+}
+
+// watchedEvents are the WinEvent ranges the eventWatcher hooks
+var watchedEvents = [][2]uint32{
+	{win32.EVENT_SYSTEM_FOREGROUND, win32.EVENT_SYSTEM_FOREGROUND},
+	{win32.EVENT_OBJECT_CREATE, win32.EVENT_OBJECT_HIDE},
+	{win32.EVENT_OBJECT_NAMECHANGE, win32.EVENT_OBJECT_NAMECHANGE},
+	// Windows can become eligible, or stop being, when they're reparented
+	// after being shown. Owner changes (SetWindowLongPtr(GWLP_HWNDPARENT))
+	// send no WinEvent at all, see ownerWatcher.
+	{win32.EVENT_OBJECT_PARENTCHANGE, win32.EVENT_OBJECT_PARENTCHANGE},
+	{win32.EVENT_OBJECT_CLOAKED, win32.EVENT_OBJECT_UNCLOAKED},
+}
+
+// start installs the hooks on a dedicated thread that pumps their messages,
+// as out-of-context WinEvent callbacks run on the installing thread
+func (w *eventWatcher) start(changed func()) error {
+	w.changed = changed
+	w.proc = w.callback
+
+	installed := make(chan error)
+	go w.run(installed)
+	return <-installed
+}
+
+func (w *eventWatcher) run(installed chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var hooks []win32.HWINEVENTHOOK
+	defer func() {
+		for _, hook := range hooks {
+			win32.UnhookWinEvent(hook)
+		}
+	}()
+	for _, events := range watchedEvents {
+		hook, err := win32.SetWinEventHook(events[0], events[1], 0, w.proc, 0, 0,
+			win32.WINEVENT_OUTOFCONTEXT|win32.WINEVENT_SKIPOWNPROCESS)
+		if err != nil {
+			installed <- err
+			return
+		}
+		hooks = append(hooks, hook)
+	}
+	installed <- nil
+	log.Println("Window event hooks installed")
+
+	msg := &win32.MSG{}
+	for {
+		if ret, err := win32.GetMessage(msg, 0, 0, 0); ret <= 0 || err != nil {
+			break
+		}
+		win32.TranslateMessage(msg)
+		win32.DispatchMessage(msg)
+	}
+}
+
+func (w *eventWatcher) callback(hook win32.HWINEVENTHOOK, event uint32, hwnd windows.HWND, idObject, idChild int32, thread, eventTime uint32) (ret uintptr) {
+	defer func() {
+		if r := recover(); r != nil {
+			win32.LogCallbackPanic("window event hook", r)
+			ret = 0
+		}
+	}()
+
+	// Only events about windows themselves, not their contents
+	if hwnd == 0 || idObject != win32.OBJID_WINDOW || idChild != win32.CHILDID_SELF {
+		return 0
+	}
+
+	switch event {
+	case win32.EVENT_OBJECT_DESTROY:
+		// A destroyed window can't be inspected anymore, but only the ones
+		// we list matter
+		if _, ok := FindWindow(hwnd); !ok {
+			return 0
+		}
+	case win32.EVENT_OBJECT_PARENTCHANGE:
+		// Matters both for windows that just became top-level and for
+		// listed ones that just stopped being
+		if _, ok := FindWindow(hwnd); !ok && win32.GetAncestor(hwnd, win32.GA_ROOT) != hwnd {
+			return 0
+		}
+	default:
+		if win32.GetAncestor(hwnd, win32.GA_ROOT) != hwnd {
+			return 0
+		}
+	}
+
+	w.changed()
+	if event == win32.EVENT_OBJECT_CREATE || event == win32.EVENT_OBJECT_SHOW {
+		w.afterSettled()
+	}
+	return 0
+}
+
+// afterSettled schedules updates for when a new window will have been around
+// long enough to be listed (see UserWindow.settled), since nothing else may
+// happen to it by then to trigger one
+func (w *eventWatcher) afterSettled() {
+	for _, delayMs := range []int{config.NewWindowDelayMs, config.SplashWindowDelayMs} {
+		time.AfterFunc(time.Duration(delayMs)*time.Millisecond, w.changed)
+	}
+}
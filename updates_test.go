@@ -0,0 +1,34 @@
+package main
+
+import (
+	"maps"
+	"tabswitcher/win32/win32test"
+	"testing"
+)
+
+func TestWindowOwnersSeeOwnerChanges(t *testing.T) {
+	p := &win32test.FakeProvider{Windows: []win32test.FakeWindow{
+		{Hwnd: 1, ClassName: "Main", Visible: true},
+		{Hwnd: 2, ClassName: "Palette", Visible: true},
+		{Hwnd: 3, ClassName: "Hidden"},
+	}}
+	useProvider(t, p)
+
+	before := windowOwners()
+	if len(before) != 2 || before[1] != 1 || before[2] != 2 {
+		t.Fatalf("windowOwners() = %v, want the two visible windows owning themselves", before)
+	}
+	if !maps.Equal(before, windowOwners()) {
+		t.Error("owners changed without any window changing")
+	}
+
+	// The palette is docked to the main window, which sends no WinEvent
+	p.Windows[1].Owner = 1
+	after := windowOwners()
+	if maps.Equal(before, after) {
+		t.Error("the new owner wasn't noticed")
+	}
+	if after[2] != 1 {
+		t.Errorf("the palette's owner is %v, want 1", after[2])
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"tabswitcher/win32"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// DesktopGroup is the windows on one virtual desktop
+type DesktopGroup struct {
+	// ID is the virtual desktop's GUID, empty for the group of windows whose
+	// desktop isn't known
+	ID string
+	// Index is the desktop's position in Task View, -1 when it isn't known
+	Index int
+	Name  string
+	// Windows are sorted by config.SortMode
+	Windows []UserWindow
+}
+
+// unknownDesktopName is the name of the group for windows whose desktop
+// couldn't be found out, which is every window when virtual desktops aren't
+// available
+const unknownDesktopName = "Unknown desktop"
+
+// otherDesktopWindows remembers the windows GetWindowsByDesktop found on
+// other virtual desktops, so their details are only loaded once. Windows on
+// the current desktop are tracked in userWindows as usual.
+var otherDesktopWindows = struct {
+	sync.Mutex
+	byHwnd map[windows.HWND]UserWindow
+}{}
+
+// GetWindowsByDesktop returns the windows of every virtual desktop, grouped
+// by desktop in Task View order. Activating one of them with activateWindow
+// switches to its desktop first.
+func GetWindowsByDesktop() ([]DesktopGroup, error) {
+	current, err := GetAltTabWindows()
+	if err != nil || !virtualDesktopsAvailable {
+		sortWindows(current, config.SortMode)
+		return []DesktopGroup{unknownDesktopGroup(current)}, err
+	}
+
+	all := append(current, windowsOnOtherDesktops()...)
+	sortWindows(all, config.SortMode)
+
+	hwnds := make([]windows.HWND, len(all))
+	for i, window := range all {
+		hwnds[i] = window.Hwnd
+	}
+	desktopIds, err := win32.WindowDesktopIDs(hwnds)
+	if err != nil {
+		log.Printf("Failed to look up virtual desktops: %v", err)
+		return []DesktopGroup{unknownDesktopGroup(all)}, nil
+	}
+
+	order, err := win32.VirtualDesktopOrder()
+	if err != nil {
+		log.Printf("Failed to read the virtual desktop order: %v", err)
+	}
+
+	// Desktops Explorer listed come first in its order, any others after
+	// them in the order their windows came in
+	groups := make([]DesktopGroup, 0, len(order))
+	index := map[windows.GUID]int{}
+	for i, id := range order {
+		index[id] = len(groups)
+		groups = append(groups, desktopGroup(id, i))
+	}
+	var unknown []UserWindow
+	for _, window := range all {
+		id, ok := desktopIds[window.Hwnd]
+		if !ok || id == (windows.GUID{}) {
+			unknown = append(unknown, window)
+			continue
+		}
+		i, ok := index[id]
+		if !ok {
+			i = len(groups)
+			index[id] = i
+			groups = append(groups, desktopGroup(id, -1))
+		}
+		groups[i].Windows = append(groups[i].Windows, window)
+	}
+	if len(unknown) > 0 {
+		groups = append(groups, unknownDesktopGroup(unknown))
+	}
+	return groups, nil
+}
+
+func unknownDesktopGroup(list []UserWindow) DesktopGroup {
+	return DesktopGroup{Index: -1, Name: unknownDesktopName, Windows: list}
+}
+
+// desktopGroup returns an empty group for the desktop id at index, named
+// like Task View names it
+func desktopGroup(id windows.GUID, index int) DesktopGroup {
+	name := win32.VirtualDesktopName(id)
+	if name == "" && index >= 0 {
+		name = fmt.Sprintf("Desktop %d", index+1)
+	}
+	return DesktopGroup{ID: id.String(), Index: index, Name: name}
+}
+
+// windowsOnOtherDesktops returns the windows that would be in Alt+Tab if
+// they were on the current desktop. The shell cloaks the windows of other
+// desktops, which is what keeps them out of GetAltTabWindows.
+func windowsOnOtherDesktops() []UserWindow {
+	hwnds, err := windowProvider.EnumWindows()
+	if err != nil {
+		log.Printf("Error enumerating windows: %v", err)
+		return nil
+	}
+	hwnds = uniqueHwnds(hwnds)
+	onCurrentDesktop, err := win32.WindowsOnCurrentDesktop(hwnds)
+	if err != nil {
+		log.Printf("Failed to check virtual desktops: %v", err)
+		return nil
+	}
+
+	otherDesktopWindows.Lock()
+	defer otherDesktopWindows.Unlock()
+
+	seen := make(map[windows.HWND]UserWindow)
+	var result []UserWindow
+	for _, hwnd := range hwnds {
+		// Windows checked as being on the current desktop are listed by
+		// GetAltTabWindows already, or cloaked for some other reason
+		if onCurrent, ok := onCurrentDesktop[hwnd]; !ok || onCurrent {
+			continue
+		}
+		if windowProvider.Cloaked(hwnd) != win32.DWM_CLOAKED_SHELL {
+			continue
+		}
+		eligible := win32.AltTabEligible(win32.AltTabAttributes{
+			Visible:     windowProvider.IsWindowVisible(hwnd),
+			ExStyle:     windowProvider.ExStyle(hwnd),
+			IsRootOwner: windowProvider.Ancestor(hwnd, win32.GA_ROOTOWNER) == hwnd,
+		})
+		if !eligible {
+			continue
+		}
+		caption, err := windowProvider.Caption(hwnd)
+		if err != nil || caption == "" {
+			continue
+		}
+
+		var processId win32.DWORD
+		win32.GetWindowThreadProcessId(hwnd, &processId)
+		window, ok := otherDesktopWindows.byHwnd[hwnd]
+		if !ok || window.process() != processKeyOf(uint32(processId)) {
+			window = UserWindow{ID: nextWindowID.Add(1), Hwnd: hwnd}
+			window.Caption = caption
+			if err := loadWindowDetails(&window); err != nil {
+				continue
+			}
+			// Always loaded, as GetWindowIconByID only knows the windows
+			// on the current desktop
+			if _, err := loadWindowIcon(&window); err != nil {
+				continue
+			}
+		}
+		if processHidden(window.ExePath) {
+			continue
+		}
+
+		window.Caption = caption
+		window.DisplayTitle = displayTitle(window)
+		window.Subtitle = documentPath(window.Caption)
+		category := windowCategory(window.ExePath)
+		window.Category, window.CategoryColor = category.Label, category.Color
+		window.AppID = appGroupKey(window)
+		window.Dpi = win32.GetWindowDpi(hwnd)
+		// activateWindow switches desktops for these, so only elevation
+		// can get in the way
+		window.CanActivate = canActivate(window, true)
+		window.AccentColor = window.IconColor
+		if color, ok := win32.GetWindowAccentColor(hwnd); ok {
+			window.AccentColor = colorRefToHex(color)
+		}
+		seen[hwnd] = window
+		window.updateActivity(time.Now())
+		result = append(result, window)
+	}
+	otherDesktopWindows.byHwnd = seen
+	return result
+}
@@ -0,0 +1,53 @@
+package win32
+
+import "sync/atomic"
+
+// handleCounts tracks the icons and bitmaps created on our behalf by the
+// wrappers in this package, and how many of them were freed again
+var handleCounts struct {
+	iconsCreated   atomic.Int64
+	iconsDestroyed atomic.Int64
+	bitmapsCreated atomic.Int64
+	bitmapsDeleted atomic.Int64
+}
+
+// HandleCounts is a snapshot of the icon and bitmap handle counters. Icons
+// borrowed from windows or their class (WM_GETICON, GCLP_HICON) and shared
+// system icons aren't ours to free, so they aren't counted.
+type HandleCounts struct {
+	IconsCreated   int64
+	IconsDestroyed int64
+	BitmapsCreated int64
+	BitmapsDeleted int64
+}
+
+// OutstandingIcons is the number of created icons not destroyed yet
+func (c HandleCounts) OutstandingIcons() int64 {
+	return c.IconsCreated - c.IconsDestroyed
+}
+
+// OutstandingBitmaps is the number of created bitmaps not deleted yet
+func (c HandleCounts) OutstandingBitmaps() int64 {
+	return c.BitmapsCreated - c.BitmapsDeleted
+}
+
+// GetHandleCounts returns the current handle counters. Comparing the
+// outstanding counts before and after a number of icon extractions shows
+// whether any handles leaked.
+func GetHandleCounts() HandleCounts {
+	return HandleCounts{
+		IconsCreated:   handleCounts.iconsCreated.Load(),
+		IconsDestroyed: handleCounts.iconsDestroyed.Load(),
+		BitmapsCreated: handleCounts.bitmapsCreated.Load(),
+		BitmapsDeleted: handleCounts.bitmapsDeleted.Load(),
+	}
+}
+
+// countIconBitmaps records the bitmaps GetIconInfo(ExW) hands to the caller
+func countIconBitmaps(hbmMask, hbmColor HBITMAP) {
+	for _, hbm := range []HBITMAP{hbmMask, hbmColor} {
+		if hbm != 0 {
+			handleCounts.bitmapsCreated.Add(1)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package win32
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIconExtractionDoesNotLeakHandles(t *testing.T) {
+	shell32 := filepath.Join(os.Getenv("SystemRoot"), "System32", "shell32.dll")
+
+	extract := func() {
+		info := GetWindowIcon(0, shell32, 0, []string{IconSourceExe})
+		if _, err := EncodeIcon(info.Icon); err != nil {
+			t.Fatalf("EncodeIcon of the %s icon: %v", info.Source, err)
+		}
+		if info.Owned {
+			DestroyIcon(info.Icon)
+		}
+
+		icon, err := GetFileTypeIcon("notes.txt")
+		if err != nil {
+			t.Fatalf("GetFileTypeIcon: %v", err)
+		}
+		if _, err := EncodeIcon(icon); err != nil {
+			t.Fatalf("EncodeIcon of the file type icon: %v", err)
+		}
+		DestroyIcon(icon)
+	}
+	// Warm up, so handles cached on first use don't count as leaked
+	extract()
+
+	before := GetHandleCounts()
+	for range 200 {
+		extract()
+	}
+	after := GetHandleCounts()
+
+	if after.IconsCreated == before.IconsCreated {
+		t.Fatal("no icons were counted as created")
+	}
+	if delta := after.OutstandingIcons() - before.OutstandingIcons(); delta != 0 {
+		t.Errorf("%d icons leaked over 200 cycles", delta)
+	}
+	if delta := after.OutstandingBitmaps() - before.OutstandingBitmaps(); delta != 0 {
+		t.Errorf("%d bitmaps leaked over 200 cycles", delta)
+	}
+}
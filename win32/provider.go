@@ -0,0 +1,159 @@
+package win32
+
+import (
+	"slices"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// WindowProvider abstracts the window queries used to decide which windows
+// belong in the switcher, so the filtering logic can run against a synthetic
+// set of windows instead of the live desktop.
+type WindowProvider interface {
+	EnumWindows() ([]windows.HWND, error)
+	IsWindowVisible(hwnd windows.HWND) bool
+	IsIconic(hwnd windows.HWND) bool
+	ClassName(hwnd windows.HWND) (string, error)
+	Caption(hwnd windows.HWND) (string, error)
+	ExStyle(hwnd windows.HWND) uintptr
+	Cloaked(hwnd windows.HWND) uint32
+	Ancestor(hwnd windows.HWND, gaFlags uint32) windows.HWND
+	LastActivePopup(hwnd windows.HWND) windows.HWND
+	ShellWindow() windows.HWND
+	Bounds(hwnd windows.HWND) (RECT, error)
+}
+
+// SystemWindows is the WindowProvider backed by the real Win32 APIs
+var SystemWindows WindowProvider = systemWindowProvider{}
+
+// SystemTopLevelWindows is SystemWindows enumerating with EnumWindows instead
+// of EnumDesktopWindows, see ListTopLevelWindows
+var SystemTopLevelWindows WindowProvider = systemWindowProvider{topLevel: true}
+
+type systemWindowProvider struct {
+	topLevel bool
+}
+
+func (p systemWindowProvider) EnumWindows() ([]windows.HWND, error) {
+	list := ListDesktopWindows
+	if p.topLevel {
+		list = ListTopLevelWindows
+	}
+	var hwnds []windows.HWND
+	for res := range list() {
+		if res.Error != nil {
+			return hwnds, res.Error
+		}
+		hwnds = append(hwnds, res.Window)
+	}
+	return hwnds, nil
+}
+
+func (systemWindowProvider) IsWindowVisible(hwnd windows.HWND) bool {
+	return windows.IsWindowVisible(hwnd)
+}
+
+func (systemWindowProvider) IsIconic(hwnd windows.HWND) bool {
+	return IsIconic(hwnd)
+}
+
+func (systemWindowProvider) ClassName(hwnd windows.HWND) (string, error) {
+	className := make([]uint16, 256)
+	n, err := GetClassNameW(hwnd, &className[0], int32(len(className)))
+	if err != nil {
+		return "", err
+	}
+	return UTF16PrefixToString(className, int(n)), nil
+}
+
+// captionTimeoutMs bounds how long a window gets to answer WM_GETTEXT
+const captionTimeoutMs = 100
+
+// Caption returns the window's title. GetWindowTextW doesn't ask windows of
+// other processes for their title, and a few apps (some Electron and Java
+// windows) only report it when asked with WM_GETTEXT, so that's tried when
+// GetWindowTextW comes back empty. An untitled window yields "".
+func (systemWindowProvider) Caption(hwnd windows.HWND) (string, error) {
+	caption := make([]uint16, 256)
+	n, err := GetWindowTextW(hwnd, &caption[0], int32(len(caption)))
+	if err == nil && n > 0 {
+		return UTF16PrefixToString(caption, int(n)), nil
+	}
+
+	var copied uintptr
+	err = SendMessageTimeoutW(hwnd, WM_GETTEXT, WPARAM(len(caption)), LPARAM(unsafe.Pointer(&caption[0])),
+		SMTO_ABORTIFHUNG, captionTimeoutMs, &copied)
+	if err != nil {
+		return "", err
+	}
+	return UTF16PrefixToString(caption, int(copied)), nil
+}
+
+// UTF16PrefixToString decodes the first n code units of buf. Text that didn't
+// fit the buffer is cut off by Windows without regard for surrogate pairs, so
+// a dangling high surrogate at the end is dropped instead of being decoded as
+// U+FFFD.
+func UTF16PrefixToString(buf []uint16, n int) string {
+	n = min(max(n, 0), len(buf))
+	if i := slices.Index(buf[:n], 0); i >= 0 {
+		n = i
+	}
+	if n > 0 && buf[n-1] >= 0xD800 && buf[n-1] < 0xDC00 {
+		n--
+	}
+	return string(utf16.Decode(buf[:n]))
+}
+
+func (systemWindowProvider) ExStyle(hwnd windows.HWND) uintptr {
+	// A window that's gone reads as having no extended styles
+	exStyle, _ := GetWindowLongPtrW(hwnd, GWL_EXSTYLE)
+	return exStyle
+}
+
+func (systemWindowProvider) Cloaked(hwnd windows.HWND) uint32 {
+	var cloaked uint32
+	err := DwmGetWindowAttribute(
+		hwnd,
+		DWMWA_CLOAKED,
+		unsafe.Pointer(&cloaked),
+		uint32(unsafe.Sizeof(cloaked)),
+	)
+	if err != nil {
+		return 0
+	}
+	return cloaked
+}
+
+func (systemWindowProvider) Ancestor(hwnd windows.HWND, gaFlags uint32) windows.HWND {
+	return GetAncestor(hwnd, gaFlags)
+}
+
+func (systemWindowProvider) LastActivePopup(hwnd windows.HWND) windows.HWND {
+	return GetLastActivePopup(hwnd)
+}
+
+func (systemWindowProvider) ShellWindow() windows.HWND {
+	return GetShellWindow()
+}
+
+// Bounds returns the window's visible bounds. GetWindowRect includes the
+// invisible resize borders Windows 10 and later draw around windows, so the
+// DWM extended frame bounds are preferred when composition is available.
+func (systemWindowProvider) Bounds(hwnd windows.HWND) (RECT, error) {
+	var rect RECT
+	err := DwmGetWindowAttribute(
+		hwnd,
+		DWMWA_EXTENDED_FRAME_BOUNDS,
+		unsafe.Pointer(&rect),
+		uint32(unsafe.Sizeof(rect)),
+	)
+	if err == nil {
+		return rect, nil
+	}
+	if err := GetWindowRect(hwnd, &rect); err != nil {
+		return RECT{}, err
+	}
+	return rect, nil
+}
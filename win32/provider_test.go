@@ -0,0 +1,178 @@
+package win32_test
+
+import (
+	"errors"
+	"slices"
+	"tabswitcher/win32"
+	"tabswitcher/win32/win32test"
+	"testing"
+	"unicode/utf16"
+
+	"golang.org/x/sys/windows"
+)
+
+// altTabWindows is the filtering done for the switcher, against p
+func altTabWindows(p win32.WindowProvider) []windows.HWND {
+	hwnds, _ := p.EnumWindows()
+	var listed []windows.HWND
+	for _, hwnd := range hwnds {
+		if win32.IsAltTabWindowFor(p, hwnd) && win32.IsEligibleForActivation(p, hwnd, p.ShellWindow()) {
+			listed = append(listed, hwnd)
+		}
+	}
+	return listed
+}
+
+func TestFilteringWithFakeProvider(t *testing.T) {
+	p := &win32test.FakeProvider{
+		Shell: 1,
+		Windows: []win32test.FakeWindow{
+			{Hwnd: 1, ClassName: "Progman", Visible: true},
+			{Hwnd: 2, ClassName: "Notepad", Caption: "notes.txt", Visible: true},
+			{Hwnd: 3, ClassName: "Hidden", Visible: false},
+			{Hwnd: 4, ClassName: "Tooltip", Visible: true, ExStyle: win32.WS_EX_TOOLWINDOW},
+			{Hwnd: 5, ClassName: "Dialog", Visible: true, Owner: 2},
+			{Hwnd: 6, ClassName: "Store", Visible: true, Cloaked: win32.DWM_CLOAKED_SHELL},
+			// An app with a taskbar dialog up is listed as the dialog
+			{Hwnd: 7, ClassName: "Editor", Visible: true, LastActivePopup: 8},
+			{Hwnd: 8, ClassName: "#32770", Visible: true, Owner: 7, ExStyle: win32.WS_EX_APPWINDOW},
+			{Hwnd: 9, ClassName: "Shell_TrayWnd", Visible: true},
+		},
+	}
+
+	got := altTabWindows(p)
+	want := []windows.HWND{2, 8}
+	if !slices.Equal(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+}
+
+func TestFilteringEnumError(t *testing.T) {
+	p := &win32test.FakeProvider{EnumError: errors.New("enumeration failed")}
+	if _, err := p.EnumWindows(); err == nil {
+		t.Error("EnumWindows succeeded")
+	}
+	if got := altTabWindows(p); len(got) != 0 {
+		t.Errorf("listed %v after a failed enumeration", got)
+	}
+}
+
+func TestLastVisibleActivePopUp(t *testing.T) {
+	p := &win32test.FakeProvider{
+		Windows: []win32test.FakeWindow{
+			{Hwnd: 1, Visible: true, LastActivePopup: 2},
+			{Hwnd: 2, Visible: false, LastActivePopup: 3},
+			{Hwnd: 3, Visible: true},
+			{Hwnd: 4, Visible: false},
+		},
+	}
+
+	tests := []struct {
+		hwnd, want windows.HWND
+	}{
+		{1, 3},
+		{3, 3},
+		// A hidden window without popups has nothing to activate
+		{4, 0},
+	}
+	for _, tt := range tests {
+		if got := win32.LastVisibleActivePopUp(p, tt.hwnd); got != tt.want {
+			t.Errorf("LastVisibleActivePopUp(%v) = %v, want %v", tt.hwnd, got, tt.want)
+		}
+	}
+}
+
+func TestIsAltTabWindowStyles(t *testing.T) {
+	const owner = 1
+	tests := []struct {
+		name    string
+		exStyle uintptr
+		owned   bool
+		want    bool
+	}{
+		{"plain", 0, false, true},
+		{"owned", 0, true, false},
+		{"tool", win32.WS_EX_TOOLWINDOW, false, false},
+		{"owned tool", win32.WS_EX_TOOLWINDOW, true, false},
+		{"app", win32.WS_EX_APPWINDOW, false, true},
+		{"owned app", win32.WS_EX_APPWINDOW, true, true},
+		{"tool app", win32.WS_EX_TOOLWINDOW | win32.WS_EX_APPWINDOW, false, true},
+		{"owned tool app", win32.WS_EX_TOOLWINDOW | win32.WS_EX_APPWINDOW, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := win32test.FakeWindow{Hwnd: 2, ClassName: "Window", Visible: true, ExStyle: tt.exStyle}
+			if tt.owned {
+				window.Owner = owner
+			}
+			p := &win32test.FakeProvider{
+				Windows: []win32test.FakeWindow{
+					{Hwnd: owner, ClassName: "Owner", Visible: true},
+					window,
+				},
+			}
+			if got := win32.IsAltTabWindowFor(p, window.Hwnd); got != tt.want {
+				t.Errorf("IsAltTabWindowFor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAltTabWindowSkipsShellClasses(t *testing.T) {
+	for _, class := range []string{"Progman", "WorkerW", "Shell_TrayWnd", "Shell_SecondaryTrayWnd"} {
+		t.Run(class, func(t *testing.T) {
+			// Shaped like an app window, as the desktop is with animated wallpapers
+			p := &win32test.FakeProvider{
+				Windows: []win32test.FakeWindow{{Hwnd: 1, ClassName: class, Visible: true, ExStyle: win32.WS_EX_APPWINDOW}},
+			}
+			if win32.IsAltTabWindowFor(p, 1) {
+				t.Errorf("%s window is listed", class)
+			}
+		})
+	}
+}
+
+func TestConsoleWindowsAreListed(t *testing.T) {
+	p := &win32test.FakeProvider{
+		Windows: []win32test.FakeWindow{
+			{Hwnd: 1, ClassName: "ConsoleWindowClass", Caption: "Command Prompt", Visible: true},
+			{Hwnd: 2, ClassName: "CASCADIA_HOSTING_WINDOW_CLASS", Caption: "Windows PowerShell", Visible: true},
+			// The terminal's hidden ConPTY console, which some hosts leave visible
+			{Hwnd: 3, ClassName: "PseudoConsoleWindow", Visible: true, Owner: 2, ExStyle: win32.WS_EX_APPWINDOW},
+		},
+	}
+
+	got := altTabWindows(p)
+	want := []windows.HWND{1, 2}
+	if !slices.Equal(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+}
+
+func TestUTF16PrefixToString(t *testing.T) {
+	encode := func(s string) []uint16 { return utf16.Encode([]rune(s)) }
+
+	tests := []struct {
+		name string
+		buf  []uint16
+		n    int
+		want string
+	}{
+		{"ascii", encode("Notepad"), 7, "Notepad"},
+		{"prefix", encode("Notepad"), 4, "Note"},
+		{"non-ASCII", encode("Résumé – 文档"), 11, "Résumé – 文档"},
+		{"surrogate pair", encode("Chat 😀"), 7, "Chat 😀"},
+		{"dangling high surrogate", encode("Chat 😀"), 6, "Chat "},
+		{"stops at NUL", append(encode("abc"), 0, 'd'), 5, "abc"},
+		{"n past the buffer", encode("abc"), 10, "abc"},
+		{"negative n", encode("abc"), -1, ""},
+		{"empty", nil, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := win32.UTF16PrefixToString(tt.buf, tt.n); got != tt.want {
+				t.Errorf("UTF16PrefixToString(%v, %d) = %q, want %q", tt.buf, tt.n, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,53 +2,118 @@ package win32
 
 import (
 	"bytes"
-	"encoding/base64"
 	"fmt"
 	"image"
 	"image/png"
+	"log"
+	"runtime"
+	"runtime/debug"
 	"slices"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
 )
 
 var (
-	user32                       = windows.NewLazySystemDLL("user32.dll")
-	procSetWindowsHookExW        = user32.NewProc("SetWindowsHookExW")
-	procLowLevelKeyboard         = user32.NewProc("LowLevelKeyboardProc")
-	procCallNextHookEx           = user32.NewProc("CallNextHookEx")
-	procUnhookWindowsHookEx      = user32.NewProc("UnhookWindowsHookEx")
-	procGetMessage               = user32.NewProc("GetMessageW")
-	procTranslateMessage         = user32.NewProc("TranslateMessage")
-	procDispatchMessage          = user32.NewProc("DispatchMessageW")
-	procEnumWindows              = user32.NewProc("EnumWindows")
-	procEnumDesktopWindows       = user32.NewProc("EnumDesktopWindows")
-	procGetWindowInfo            = user32.NewProc("GetWindowInfo")
-	procIsWindowVisible          = user32.NewProc("IsWindowVisible")
-	procIsIconic                 = user32.NewProc("IsIconic")
-	procGetWindowTextW           = user32.NewProc("GetWindowTextW")
-	procGetShellWindow           = user32.NewProc("GetShellWindow")
-	procGetAncestor              = user32.NewProc("GetAncestor")
-	procGetLastActivePopup       = user32.NewProc("GetLastActivePopup")
-	procGetClassNameW            = user32.NewProc("GetClassNameW")
-	procGetWindowRect            = user32.NewProc("GetWindowRect")
-	procGetWindowLongPtrW        = user32.NewProc("GetWindowLongPtrW")
-	procGetClassLongPtrW         = user32.NewProc("GetClassLongPtrW")
-	procSendMessageW             = user32.NewProc("SendMessageW")
-	procSendMessageCallbackW     = user32.NewProc("SendMessageCallbackW")
-	procLoadIconW                = user32.NewProc("LoadIconW")
-	procGetIconInfo              = user32.NewProc("GetIconInfo")
-	procGetIconInfoExW           = user32.NewProc("GetIconInfoExW")
-	procGetForegroundWindow      = user32.NewProc("GetForegroundWindow")
-	procSetForegroundWindow      = user32.NewProc("SetForegroundWindow")
-	procGetWindowThreadProcessId = user32.NewProc("GetWindowThreadProcessId")
+	user32                        = windows.NewLazySystemDLL("user32.dll")
+	procSetWindowsHookExW         = user32.NewProc("SetWindowsHookExW")
+	procLowLevelKeyboard          = user32.NewProc("LowLevelKeyboardProc")
+	procCallNextHookEx            = user32.NewProc("CallNextHookEx")
+	procUnhookWindowsHookEx       = user32.NewProc("UnhookWindowsHookEx")
+	procSetWinEventHook           = user32.NewProc("SetWinEventHook")
+	procUnhookWinEvent            = user32.NewProc("UnhookWinEvent")
+	procGetMessage                = user32.NewProc("GetMessageW")
+	procTranslateMessage          = user32.NewProc("TranslateMessage")
+	procDispatchMessage           = user32.NewProc("DispatchMessageW")
+	procEnumWindows               = user32.NewProc("EnumWindows")
+	procEnumDesktopWindows        = user32.NewProc("EnumDesktopWindows")
+	procGetWindowInfo             = user32.NewProc("GetWindowInfo")
+	procIsWindowVisible           = user32.NewProc("IsWindowVisible")
+	procIsIconic                  = user32.NewProc("IsIconic")
+	procGetWindowTextW            = user32.NewProc("GetWindowTextW")
+	procGetShellWindow            = user32.NewProc("GetShellWindow")
+	procGetAncestor               = user32.NewProc("GetAncestor")
+	procGetLastActivePopup        = user32.NewProc("GetLastActivePopup")
+	procGetClassNameW             = user32.NewProc("GetClassNameW")
+	procGetWindowRect             = user32.NewProc("GetWindowRect")
+	procGetWindowLongPtrW         = user32.NewProc("GetWindowLongPtrW")
+	procGetClassLongPtrW          = user32.NewProc("GetClassLongPtrW")
+	procSendMessageW              = user32.NewProc("SendMessageW")
+	procSendMessageCallbackW      = user32.NewProc("SendMessageCallbackW")
+	procSendMessageTimeoutW       = user32.NewProc("SendMessageTimeoutW")
+	procLoadIconW                 = user32.NewProc("LoadIconW")
+	procGetIconInfo               = user32.NewProc("GetIconInfo")
+	procGetIconInfoExW            = user32.NewProc("GetIconInfoExW")
+	procGetForegroundWindow       = user32.NewProc("GetForegroundWindow")
+	procSetForegroundWindow       = user32.NewProc("SetForegroundWindow")
+	procGetWindowThreadProcessId  = user32.NewProc("GetWindowThreadProcessId")
+	procGetAsyncKeyState          = user32.NewProc("GetAsyncKeyState")
+	procDestroyIcon               = user32.NewProc("DestroyIcon")
+	procSetWindowPos              = user32.NewProc("SetWindowPos")
+	procGetWindow                 = user32.NewProc("GetWindow")
+	procRegisterClassExW          = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW           = user32.NewProc("CreateWindowExW")
+	procDestroyWindow             = user32.NewProc("DestroyWindow")
+	procDefWindowProcW            = user32.NewProc("DefWindowProcW")
+	procRegisterWindowMessageW    = user32.NewProc("RegisterWindowMessageW")
+	procRegisterShellHookWindow   = user32.NewProc("RegisterShellHookWindow")
+	procDeregisterShellHookWindow = user32.NewProc("DeregisterShellHookWindow")
+	procSendInput                 = user32.NewProc("SendInput")
+	procGetLastInputInfo          = user32.NewProc("GetLastInputInfo")
+	procPostThreadMessageW        = user32.NewProc("PostThreadMessageW")
+	procSwitchToThisWindow        = user32.NewProc("SwitchToThisWindow")
+	procGetWindowPlacement        = user32.NewProc("GetWindowPlacement")
+	procSetWindowPlacement        = user32.NewProc("SetWindowPlacement")
+	procMonitorFromRect           = user32.NewProc("MonitorFromRect")
+	procMonitorFromWindow         = user32.NewProc("MonitorFromWindow")
+	procGetMonitorInfoW           = user32.NewProc("GetMonitorInfoW")
+	procEnumDisplayMonitors       = user32.NewProc("EnumDisplayMonitors")
+	procGetCursorPos              = user32.NewProc("GetCursorPos")
+	procSetCursorPos              = user32.NewProc("SetCursorPos")
+	procWindowFromPoint           = user32.NewProc("WindowFromPoint")
+	procFindWindowW               = user32.NewProc("FindWindowW")
+	procGetDpiForWindow           = user32.NewProc("GetDpiForWindow")
+	procShowWindow                = user32.NewProc("ShowWindow")
+	procBringWindowToTop          = user32.NewProc("BringWindowToTop")
+	procRegisterHotKey            = user32.NewProc("RegisterHotKey")
+	procUnregisterHotKey          = user32.NewProc("UnregisterHotKey")
+	procOpenClipboard             = user32.NewProc("OpenClipboard")
+	procCloseClipboard            = user32.NewProc("CloseClipboard")
+	procEmptyClipboard            = user32.NewProc("EmptyClipboard")
+	procSetClipboardData          = user32.NewProc("SetClipboardData")
+
+	shcore               = windows.NewLazySystemDLL("shcore.dll")
+	procGetDpiForMonitor = shcore.NewProc("GetDpiForMonitor")
 
 	shell32            = windows.NewLazySystemDLL("shell32.dll")
 	procExtractIconExW = shell32.NewProc("ExtractIconExW")
+	procSHGetFileInfoW = shell32.NewProc("SHGetFileInfoW")
+
+	procSHGetPropertyStoreForWindow = shell32.NewProc("SHGetPropertyStoreForWindow")
+	procSHCreateItemFromParsingName = shell32.NewProc("SHCreateItemFromParsingName")
+
+	ole32                = windows.NewLazySystemDLL("ole32.dll")
+	procPropVariantClear = ole32.NewProc("PropVariantClear")
+	procCoCreateInstance = ole32.NewProc("CoCreateInstance")
+
+	oleaut32          = windows.NewLazySystemDLL("oleaut32.dll")
+	procSysFreeString = oleaut32.NewProc("SysFreeString")
 
 	kernel32                       = windows.NewLazySystemDLL("kernel32.dll")
 	procQueryFullProcessImageNameW = kernel32.NewProc("QueryFullProcessImageNameW")
+	procGetModuleHandleW           = kernel32.NewProc("GetModuleHandleW")
+	procGetTickCount               = kernel32.NewProc("GetTickCount")
+	procSetLastError               = kernel32.NewProc("SetLastError")
+	procGlobalAlloc                = kernel32.NewProc("GlobalAlloc")
+	procGlobalFree                 = kernel32.NewProc("GlobalFree")
+	procGlobalLock                 = kernel32.NewProc("GlobalLock")
+	procGlobalUnlock               = kernel32.NewProc("GlobalUnlock")
+	procRtlMoveMemory              = kernel32.NewProc("RtlMoveMemory")
 
 	dwmapi                    = windows.NewLazySystemDLL("dwmapi.dll")
 	procDwmGetWindowAttribute = dwmapi.NewProc("DwmGetWindowAttribute")
@@ -60,9 +125,12 @@ var (
 	procReleaseDC    = user32.NewProc("ReleaseDC")
 	procGetDIBits    = gdi32.NewProc("GetDIBits")
 
-	gdiplusDLL                   = windows.NewLazySystemDLL("gdiplus.dll")
-	procGdipGetImageEncodersSize = gdiplusDLL.NewProc("GdipGetImageEncodersSize")
-	procGdipGetImageEncoders     = gdiplusDLL.NewProc("GdipGetImageEncoders")
+	procCreateCompatibleDC     = gdi32.NewProc("CreateCompatibleDC")
+	procDeleteDC               = gdi32.NewProc("DeleteDC")
+	procCreateCompatibleBitmap = gdi32.NewProc("CreateCompatibleBitmap")
+	procCreateDIBSection       = gdi32.NewProc("CreateDIBSection")
+	procSelectObject           = gdi32.NewProc("SelectObject")
+	procDrawIconEx             = user32.NewProc("DrawIconEx")
 )
 
 const (
@@ -77,7 +145,33 @@ const (
 	WM_KEYLAST     = 264
 	WM_LBUTTONDOWN = 513
 	WM_RBUTTONDOWN = 516
+	WM_GETTEXT     = 0x000D
 	WM_GETICON     = 0x007F
+	WM_COMMAND     = 0x0111
+	WM_HOTKEY      = 0x0312
+	WM_APP         = 0x8000
+
+	// SendMessageTimeout flags
+	SMTO_ABORTIFHUNG = 0x0002
+
+	// Undocumented but long-stable WM_COMMAND IDs of the taskbar
+	// (Shell_TrayWnd) behind Win+M and Win+Shift+M
+	MIN_ALL      = 419
+	MIN_ALL_UNDO = 416
+
+	// RegisterHotKey modifiers
+	MOD_ALT      = 0x0001
+	MOD_CONTROL  = 0x0002
+	MOD_SHIFT    = 0x0004
+	MOD_WIN      = 0x0008
+	MOD_NOREPEAT = 0x4000
+
+	// KBDLLHOOKSTRUCT flags
+	LLKHF_INJECTED = 0x00000010
+
+	// SendInput types and keyboard flags
+	INPUT_KEYBOARD  = 1
+	KEYEVENTF_KEYUP = 0x0002
 
 	// Icon types for WM_GETICON
 	ICON_SMALL  = 0
@@ -98,7 +192,19 @@ const (
 	WS_VISIBLE          = 0x10000000
 
 	// Extended window styles
-	WS_EX_TOOLWINDOW = 0x00000080
+	WS_EX_TOPMOST     = 0x00000008
+	WS_EX_TRANSPARENT = 0x00000020
+	WS_EX_TOOLWINDOW  = 0x00000080
+	WS_EX_APPWINDOW   = 0x00040000
+
+	// Shell hook notifications (wParam of the SHELLHOOK message)
+	HSHELL_WINDOWCREATED    = 1
+	HSHELL_WINDOWDESTROYED  = 2
+	HSHELL_WINDOWACTIVATED  = 4
+	HSHELL_REDRAW           = 6
+	HSHELL_FLASH            = HSHELL_REDRAW | HSHELL_HIGHBIT
+	HSHELL_RUDEAPPACTIVATED = HSHELL_WINDOWACTIVATED | HSHELL_HIGHBIT
+	HSHELL_HIGHBIT          = 0x8000
 
 	PM_NOREMOVE = 0x000
 	PM_REMOVE   = 0x001
@@ -110,6 +216,7 @@ const (
 	GA_ROOTOWNER = 3
 
 	// GetWindowLong indices
+	GWL_STYLE      = -16
 	GWL_EXSTYLE    = -20
 	GWLP_HINSTANCE = -6
 
@@ -118,7 +225,15 @@ const (
 	GCLP_HICONSM = -34
 
 	// DWM window attributes
-	DWMWA_CLOAKED = 14
+	DWMWA_EXTENDED_FRAME_BOUNDS = 9
+	DWMWA_CLOAKED               = 14
+	// Readable on Windows 11 (build 22000) and later only
+	DWMWA_BORDER_COLOR  = 34
+	DWMWA_CAPTION_COLOR = 35
+
+	// Special DWMWA_*_COLOR values
+	DWMWA_COLOR_DEFAULT = 0xFFFFFFFF
+	DWMWA_COLOR_NONE    = 0xFFFFFFFE
 
 	// DWM cloaked flags
 	DWM_CLOAKED_APP       = 0x00000001
@@ -137,9 +252,92 @@ const (
 	// DIB color table identifiers
 	DIB_RGB_COLORS = 0
 
+	// DrawIconEx flags
+	DI_MASK   = 0x0001
+	DI_NORMAL = 0x0003
+
+	// SetWindowPos insert-after values
+	HWND_TOP       = 0
+	HWND_BOTTOM    = 1
+	HWND_TOPMOST   = ^uintptr(0)     // -1
+	HWND_NOTOPMOST = ^uintptr(0) - 1 // -2
+
+	// SetWindowPos flags
+	SWP_NOSIZE        = 0x0001
+	SWP_NOMOVE        = 0x0002
+	SWP_NOZORDER      = 0x0004
+	SWP_NOACTIVATE    = 0x0010
+	SWP_SHOWWINDOW    = 0x0040
+	SWP_NOOWNERZORDER = 0x0200
+
+	// ShowWindow commands
+	SW_SHOWMAXIMIZED = 3
+	SW_RESTORE       = 9
+
+	// WINDOWPLACEMENT flags
+	WPF_RESTORETOMAXIMIZED = 0x0002
+
+	// Clipboard formats
+	CF_UNICODETEXT = 13
+
+	// GlobalAlloc flags
+	GMEM_MOVEABLE = 0x0002
+
+	// MonitorFrom* flags
+	MONITOR_DEFAULTTONULL    = 0
+	MONITOR_DEFAULTTOPRIMARY = 1
+	MONITOR_DEFAULTTONEAREST = 2
+
+	// GetDpiForMonitor DPI types
+	MDT_EFFECTIVE_DPI = 0
+
+	// WinEvents
+	EVENT_SYSTEM_FOREGROUND   = 0x0003
+	EVENT_OBJECT_CREATE       = 0x8000
+	EVENT_OBJECT_DESTROY      = 0x8001
+	EVENT_OBJECT_SHOW         = 0x8002
+	EVENT_OBJECT_HIDE         = 0x8003
+	EVENT_OBJECT_NAMECHANGE   = 0x800C
+	EVENT_OBJECT_PARENTCHANGE = 0x800F
+	EVENT_OBJECT_CLOAKED      = 0x8017
+	EVENT_OBJECT_UNCLOAKED    = 0x8018
+	WINEVENT_OUTOFCONTEXT     = 0x0000
+	WINEVENT_SKIPOWNPROCESS   = 0x0002
+	OBJID_WINDOW              = 0
+	CHILDID_SELF              = 0
+
+	// GetWindow commands
+	GW_HWNDNEXT = 2
+	GW_HWNDPREV = 3
+	GW_OWNER    = 4
+
+	// SHGetFileInfo flags
+	SHGFI_ICON              = 0x000000100
+	SHGFI_SMALLICON         = 0x000000001
+	SHGFI_USEFILEATTRIBUTES = 0x000000010
+
+	// File attributes
+	FILE_ATTRIBUTE_NORMAL = 0x00000080
+
+	// CoCreateInstance class contexts
+	CLSCTX_INPROC_SERVER = 0x1
+	CLSCTX_LOCAL_SERVER  = 0x4
+	CLSCTX_ALL           = 0x17
+
+	// PROPVARIANT types
+	VT_EMPTY  = 0
+	VT_LPWSTR = 31
+
 	// Process access rights
 	PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
 
+	// Integrity levels, the last sub-authority of a token's integrity SID
+	SECURITY_MANDATORY_UNTRUSTED_RID = 0x0000
+	SECURITY_MANDATORY_LOW_RID       = 0x1000
+	SECURITY_MANDATORY_MEDIUM_RID    = 0x2000
+	SECURITY_MANDATORY_HIGH_RID      = 0x3000
+	SECURITY_MANDATORY_SYSTEM_RID    = 0x4000
+
 	NULL = 0
 )
 
@@ -161,8 +359,14 @@ type (
 	LONG      int32
 )
 
+// HWINEVENTHOOK is a hook installed with SetWinEventHook
+type HWINEVENTHOOK HANDLE
+
 type HOOKPROC func(int, WPARAM, LPARAM) LRESULT
+type WINEVENTPROC func(hWinEventHook HWINEVENTHOOK, event uint32, hwnd windows.HWND, idObject int32, idChild int32, idEventThread uint32, dwmsEventTime uint32) uintptr
+type WNDPROC func(windows.HWND, uint32, WPARAM, LPARAM) LRESULT
 type WNDENUMPROC func(windows.HWND, LPARAM) uintptr
+type MONITORENUMPROC func(hMonitor HANDLE, hdc HDC, lprcMonitor *RECT, lParam LPARAM) uintptr
 type SENDASYNCPROC func(windows.HWND, uint32, uintptr, LRESULT) uintptr
 
 type RECT struct {
@@ -180,6 +384,27 @@ type KBDLLHOOKSTRUCT struct {
 	DwExtraInfo uintptr
 }
 
+type KEYBDINPUT struct {
+	WVk         WORD
+	WScan       WORD
+	DwFlags     DWORD
+	Time        DWORD
+	DwExtraInfo uintptr
+}
+
+// KEYBOARDINPUT is an INPUT structure holding a KEYBDINPUT. The padding makes
+// up for the larger MOUSEINPUT member of the union.
+type KEYBOARDINPUT struct {
+	Type    DWORD
+	Ki      KEYBDINPUT
+	padding [8]byte
+}
+
+type LASTINPUTINFO struct {
+	CbSize DWORD
+	DwTime DWORD
+}
+
 type WINDOWINFO struct {
 	CbSize          DWORD
 	RcWindow        RECT
@@ -198,6 +423,25 @@ type POINT struct {
 	X, Y int32
 }
 
+// WINDOWPLACEMENT contains the show state and restored, minimized and
+// maximized positions of a window
+type WINDOWPLACEMENT struct {
+	Length           uint32
+	Flags            uint32
+	ShowCmd          uint32
+	PtMinPosition    POINT
+	PtMaxPosition    POINT
+	RcNormalPosition RECT
+}
+
+// MONITORINFO contains the bounds and work area of a display monitor
+type MONITORINFO struct {
+	CbSize    uint32
+	RcMonitor RECT
+	RcWork    RECT
+	DwFlags   uint32
+}
+
 // http://msdn.microsoft.com/en-us/library/windows/desktop/ms644958.aspx
 type MSG struct {
 	Hwnd    windows.HWND
@@ -208,6 +452,22 @@ type MSG struct {
 	Pt      POINT
 }
 
+// WNDCLASSEXW contains window class information
+type WNDCLASSEXW struct {
+	CbSize        uint32
+	Style         uint32
+	LpfnWndProc   uintptr
+	CbClsExtra    int32
+	CbWndExtra    int32
+	HInstance     HINSTANCE
+	HIcon         HICON
+	HCursor       HANDLE
+	HbrBackground HANDLE
+	LpszMenuName  *uint16
+	LpszClassName *uint16
+	HIconSm       HICON
+}
+
 // BITMAP contains information about a bitmap
 type BITMAP struct {
 	BmType       LONG
@@ -256,23 +516,195 @@ type ICONINFOEXW struct {
 	SzResName [MAX_PATH]uint16
 }
 
-// ImageCodecInfo contains information about an image encoder/decoder
-type ImageCodecInfo struct {
-	Clsid             windows.GUID
-	FormatID          windows.GUID
-	CodecName         *uint16
-	DllName           *uint16
-	FormatDescription *uint16
-	FilenameExtension *uint16
-	MimeType          *uint16
-	Flags             DWORD
-	Version           DWORD
-	SigCount          DWORD
-	SigSize           DWORD
-	SigPattern        *byte
-	SigMask           *byte
+// SHFILEINFOW contains information about a file object
+type SHFILEINFOW struct {
+	HIcon         HICON
+	IIcon         int32
+	DwAttributes  DWORD
+	SzDisplayName [MAX_PATH]uint16
+	SzTypeName    [80]uint16
+}
+
+// PROPERTYKEY identifies a shell property
+type PROPERTYKEY struct {
+	Fmtid windows.GUID
+	Pid   DWORD
+}
+
+// PROPVARIANT holds a property value. Only the string-pointer variants are
+// read, so the union is kept as raw pointer-sized words.
+type PROPVARIANT struct {
+	Vt        WORD
+	Reserved1 WORD
+	Reserved2 WORD
+	Reserved3 WORD
+	Val       [2]uintptr
+}
+
+// LPWSTR returns the value of a VT_LPWSTR variant, which Val holds a pointer
+// to. It's read through a typed pointer to Val, so it isn't taken for a
+// uintptr turned back into a pointer.
+func (v *PROPVARIANT) LPWSTR() string {
+	return windows.UTF16PtrToString(*(**uint16)(unsafe.Pointer(&v.Val[0])))
+}
+
+// IPropertyStoreVtbl is the method table of the IPropertyStore COM interface
+type IPropertyStoreVtbl struct {
+	QueryInterface uintptr
+	AddRef         uintptr
+	Release        uintptr
+	GetCount       uintptr
+	GetAt          uintptr
+	GetValue       uintptr
+	SetValue       uintptr
+	Commit         uintptr
+}
+
+type IPropertyStore struct {
+	Vtbl *IPropertyStoreVtbl
+}
+
+var (
+	IID_IPropertyStore = windows.GUID{
+		Data1: 0x886D8EEB,
+		Data2: 0x8CF2,
+		Data3: 0x4446,
+		Data4: [8]byte{0x8D, 0x02, 0xCD, 0xBA, 0x1D, 0xBD, 0xCF, 0x99},
+	}
+
+	PKEY_AppUserModel_ID = PROPERTYKEY{
+		Fmtid: windows.GUID{
+			Data1: 0x9F4C2855,
+			Data2: 0x9F79,
+			Data3: 0x4B39,
+			Data4: [8]byte{0xA8, 0xD0, 0xE1, 0xD4, 0x2D, 0xE1, 0xD5, 0xF3},
+		},
+		Pid: 5,
+	}
+)
+
+// IShellItemVtbl is the method table of the IShellItem COM interface
+type IShellItemVtbl struct {
+	QueryInterface uintptr
+	AddRef         uintptr
+	Release        uintptr
+	BindToHandler  uintptr
+	GetParent      uintptr
+	GetDisplayName uintptr
+	GetAttributes  uintptr
+	Compare        uintptr
+}
+
+type IShellItem struct {
+	Vtbl *IShellItemVtbl
+}
+
+var IID_IShellItem = windows.GUID{
+	Data1: 0x43826D1E,
+	Data2: 0xE718,
+	Data3: 0x42EE,
+	Data4: [8]byte{0xBC, 0x55, 0xA1, 0xE2, 0x61, 0xC3, 0x7B, 0xFE},
+}
+
+// IShellItem.GetDisplayName forms
+const SIGDN_NORMALDISPLAY = 0
+
+// IVirtualDesktopManagerVtbl is the method table of the documented
+// IVirtualDesktopManager COM interface
+type IVirtualDesktopManagerVtbl struct {
+	QueryInterface                  uintptr
+	AddRef                          uintptr
+	Release                         uintptr
+	IsWindowOnCurrentVirtualDesktop uintptr
+	GetWindowDesktopId              uintptr
+	MoveWindowToDesktop             uintptr
+}
+
+type IVirtualDesktopManager struct {
+	Vtbl *IVirtualDesktopManagerVtbl
+}
+
+var (
+	CLSID_VirtualDesktopManager = windows.GUID{
+		Data1: 0xAA509086,
+		Data2: 0x5CA9,
+		Data3: 0x4C25,
+		Data4: [8]byte{0x8F, 0x95, 0x58, 0x9D, 0x3C, 0x07, 0xB4, 0x8A},
+	}
+
+	IID_IVirtualDesktopManager = windows.GUID{
+		Data1: 0xA5CD92FF,
+		Data2: 0x29BE,
+		Data3: 0x454C,
+		Data4: [8]byte{0x8D, 0x04, 0xD8, 0x28, 0x79, 0xFB, 0x3F, 0x1B},
+	}
+)
+
+// IUIAutomationVtbl is the start of the method table of the IUIAutomation COM
+// interface, up to the methods used here
+type IUIAutomationVtbl struct {
+	QueryInterface    uintptr
+	AddRef            uintptr
+	Release           uintptr
+	CompareElements   uintptr
+	CompareRuntimeIds uintptr
+	GetRootElement    uintptr
+	ElementFromHandle uintptr
+}
+
+type IUIAutomation struct {
+	Vtbl *IUIAutomationVtbl
+}
+
+// IUIAutomationElementVtbl is the start of the method table of the
+// IUIAutomationElement COM interface, up to get_CurrentName
+type IUIAutomationElementVtbl struct {
+	QueryInterface                  uintptr
+	AddRef                          uintptr
+	Release                         uintptr
+	SetFocus                        uintptr
+	GetRuntimeId                    uintptr
+	FindFirst                       uintptr
+	FindAll                         uintptr
+	FindFirstBuildCache             uintptr
+	FindAllBuildCache               uintptr
+	BuildUpdatedCache               uintptr
+	GetCurrentPropertyValue         uintptr
+	GetCurrentPropertyValueEx       uintptr
+	GetCachedPropertyValue          uintptr
+	GetCachedPropertyValueEx        uintptr
+	GetCurrentPatternAs             uintptr
+	GetCachedPatternAs              uintptr
+	GetCurrentPattern               uintptr
+	GetCachedPattern                uintptr
+	GetCachedParent                 uintptr
+	GetCachedChildren               uintptr
+	Get_CurrentProcessId            uintptr
+	Get_CurrentControlType          uintptr
+	Get_CurrentLocalizedControlType uintptr
+	Get_CurrentName                 uintptr
 }
 
+type IUIAutomationElement struct {
+	Vtbl *IUIAutomationElementVtbl
+}
+
+var (
+	CLSID_CUIAutomation = windows.GUID{
+		Data1: 0xFF48DBA4,
+		Data2: 0x60EF,
+		Data3: 0x4201,
+		Data4: [8]byte{0xAA, 0x87, 0x54, 0x10, 0x3E, 0xEF, 0x59, 0x4E},
+	}
+
+	IID_IUIAutomation = windows.GUID{
+		Data1: 0x30CBE57D,
+		Data2: 0xD9D0,
+		Data3: 0x452A,
+		Data4: [8]byte{0xAB, 0x13, 0x7A, 0xC5, 0xAC, 0x48, 0x25, 0xEE},
+	}
+)
+
 func SetWindowsHookExW(idHook int, lpfn HOOKPROC, hMod HINSTANCE, dwThreadId DWORD) (HHOOK, error) {
 	ret, _, err := procSetWindowsHookExW.Call(
 		uintptr(idHook),
@@ -286,6 +718,30 @@ func SetWindowsHookExW(idHook int, lpfn HOOKPROC, hMod HINSTANCE, dwThreadId DWO
 	return HHOOK(ret), nil
 }
 
+// SetWinEventHook installs lpfn for the events from eventMin to eventMax. With
+// WINEVENT_OUTOFCONTEXT it's called on the installing thread, which must pump
+// messages.
+func SetWinEventHook(eventMin, eventMax uint32, hmodWinEventProc HINSTANCE, lpfn WINEVENTPROC, idProcess, idThread uint32, dwFlags uint32) (HWINEVENTHOOK, error) {
+	ret, _, err := procSetWinEventHook.Call(
+		uintptr(eventMin),
+		uintptr(eventMax),
+		uintptr(hmodWinEventProc),
+		syscall.NewCallback(lpfn),
+		uintptr(idProcess),
+		uintptr(idThread),
+		uintptr(dwFlags),
+	)
+	if ret == 0 {
+		return 0, err
+	}
+	return HWINEVENTHOOK(ret), nil
+}
+
+func UnhookWinEvent(hWinEventHook HWINEVENTHOOK) bool {
+	ret, _, _ := procUnhookWinEvent.Call(uintptr(hWinEventHook))
+	return ret != 0
+}
+
 func CallNextHookEx(hhk HHOOK, nCode int, wParam WPARAM, lParam LPARAM) LRESULT {
 	ret, _, _ := procCallNextHookEx.Call(
 		uintptr(hhk),
@@ -367,7 +823,22 @@ type EnumWindowsResult struct {
 	Error  error
 }
 
-func enumDesktopWindowsCallback(hwnd windows.HWND, lParam LPARAM) uintptr {
+// LogCallbackPanic logs a panic recovered in a callback called by Windows.
+// A panic unwinding into the native caller would take the whole process
+// down, so callbacks recover and return a harmless value instead.
+func LogCallbackPanic(callback string, recovered any) {
+	log.Printf("Recovered from a panic in the %s callback: %v\n%s", callback, recovered, debug.Stack())
+}
+
+func enumDesktopWindowsCallback(hwnd windows.HWND, lParam LPARAM) (ret uintptr) {
+	defer func() {
+		if r := recover(); r != nil {
+			LogCallbackPanic("window enumeration", r)
+			// Skip the window but keep enumerating
+			ret = 1
+		}
+	}()
+
 	ch := (*chan EnumWindowsResult)(unsafe.Pointer(lParam))
 	*ch <- EnumWindowsResult{Window: hwnd}
 	return 1
@@ -388,6 +859,25 @@ func ListDesktopWindows() chan EnumWindowsResult {
 	return ch
 }
 
+// ListTopLevelWindows is ListDesktopWindows using EnumWindows, which walks
+// the top-level windows of the desktop the calling thread is on, rather than
+// EnumDesktopWindows with no desktop, which Windows resolves to the thread's
+// desktop at the time of the call. They're expected to agree.
+func ListTopLevelWindows() chan EnumWindowsResult {
+	ch := make(chan EnumWindowsResult)
+	lParam := LPARAM(unsafe.Pointer(&ch))
+
+	go func() {
+		err := EnumWindows((WNDENUMPROC)(enumDesktopWindowsCallback), lParam)
+		if err != nil {
+			ch <- EnumWindowsResult{Error: err}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
 func IswindowVisible(hwnd windows.HWND) bool {
 	ret, _, _ := procIsWindowVisible.Call(
 		uintptr(hwnd),
@@ -395,6 +885,7 @@ func IswindowVisible(hwnd windows.HWND) bool {
 	return ret != 0
 }
 
+// IsIconic reports whether hwnd is minimized. It has no failure to report.
 func IsIconic(hwnd windows.HWND) bool {
 	ret, _, _ := procIsIconic.Call(
 		uintptr(hwnd),
@@ -424,16 +915,35 @@ func GetWindowRect(hwnd windows.HWND, rect *RECT) error {
 	return nil
 }
 
+// GetWindowTextW copies the window's title into str. An untitled window
+// reads as 0 characters without an error.
 func GetWindowTextW(hwnd windows.HWND, str *uint16, maxCount int32) (int32, error) {
-	ret, _, err := procGetWindowTextW.Call(
+	ret, err := callClearingLastError(procGetWindowTextW,
 		uintptr(hwnd),
 		uintptr(unsafe.Pointer(str)),
 		uintptr(maxCount),
 	)
-	if ret == 0 {
+	return int32(ret), err
+}
+
+// SetLastError sets the calling thread's last error code
+func SetLastError(code uint32) {
+	procSetLastError.Call(uintptr(code))
+}
+
+// callClearingLastError calls proc for functions whose 0 result can be valid,
+// and which only report failure through the last error. The last error is
+// cleared first, on the same locked thread, since it's kept per thread.
+func callClearingLastError(proc *windows.LazyProc, args ...uintptr) (uintptr, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	SetLastError(0)
+	ret, _, err := proc.Call(args...)
+	if errno, ok := err.(syscall.Errno); ret == 0 && (!ok || errno != 0) {
 		return 0, err
 	}
-	return int32(ret), nil
+	return ret, nil
 }
 
 func GetShellWindow() windows.HWND {
@@ -441,6 +951,16 @@ func GetShellWindow() windows.HWND {
 	return windows.HWND(ret)
 }
 
+func FindWindowW(lpClassName, lpWindowName *uint16) windows.HWND {
+	ret, _, _ := procFindWindowW.Call(
+		uintptr(unsafe.Pointer(lpClassName)),
+		uintptr(unsafe.Pointer(lpWindowName)),
+	)
+	return windows.HWND(ret)
+}
+
+// GetAncestor returns 0 only when hwnd isn't a window, which callers can
+// check for themselves
 func GetAncestor(hwnd windows.HWND, gaFlags uint32) windows.HWND {
 	ret, _, _ := procGetAncestor.Call(
 		uintptr(hwnd),
@@ -468,25 +988,26 @@ func GetClassNameW(hwnd windows.HWND, str *uint16, maxCount int32) (int32, error
 	return int32(ret), nil
 }
 
-func GetWindowLongPtrW(hwnd windows.HWND, nIndex int32) uintptr {
-	ret, _, _ := procGetWindowLongPtrW.Call(
+// GetWindowLongPtrW reads a window attribute. Zero is a valid value for
+// most of them, so failure is only told apart by the last error.
+func GetWindowLongPtrW(hwnd windows.HWND, nIndex int32) (uintptr, error) {
+	return callClearingLastError(procGetWindowLongPtrW,
 		uintptr(hwnd),
 		uintptr(nIndex),
 	)
-	return ret
 }
 
+// GetClassLongPtrW reads a window class attribute. A class without the
+// attribute (e.g. no icon) reads as 0 without an error.
 func GetClassLongPtrW(hwnd windows.HWND, nIndex int32) (uintptr, error) {
-	ret, _, err := procGetClassLongPtrW.Call(
+	return callClearingLastError(procGetClassLongPtrW,
 		uintptr(hwnd),
 		uintptr(nIndex),
 	)
-	if ret == 0 {
-		return 0, err
-	}
-	return ret, nil
 }
 
+// SendMessage returns the message's result, whose meaning depends on msg.
+// Failure isn't reported, see SendMessageTimeoutW for windows that may hang.
 func SendMessage(hwnd windows.HWND, msg uint32, wParam WPARAM, lParam LPARAM) LRESULT {
 	ret, _, _ := procSendMessageW.Call(
 		uintptr(hwnd),
@@ -497,6 +1018,22 @@ func SendMessage(hwnd windows.HWND, msg uint32, wParam WPARAM, lParam LPARAM) LR
 	return LRESULT(ret)
 }
 
+func SendMessageTimeoutW(hwnd windows.HWND, msg uint32, wParam WPARAM, lParam LPARAM, fuFlags uint32, uTimeout uint32, lpdwResult *uintptr) error {
+	ret, _, err := procSendMessageTimeoutW.Call(
+		uintptr(hwnd),
+		uintptr(msg),
+		uintptr(wParam),
+		uintptr(lParam),
+		uintptr(fuFlags),
+		uintptr(uTimeout),
+		uintptr(unsafe.Pointer(lpdwResult)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
 func SendMessageCallbackW(hwnd windows.HWND, msg uint32, wParam WPARAM, lParam LPARAM, lpResultCallBack SENDASYNCPROC, dwData uintptr) error {
 	ret, _, err := procSendMessageCallbackW.Call(
 		uintptr(hwnd),
@@ -528,6 +1065,7 @@ func GetIconInfo(hIcon HICON, piconinfo *ICONINFO) error {
 	if ret == 0 {
 		return err
 	}
+	countIconBitmaps(piconinfo.HbmMask, piconinfo.HbmColor)
 	return nil
 }
 
@@ -542,6 +1080,7 @@ func GetIconInfoExW(hIcon HICON, piconinfo *ICONINFOEXW) error {
 	if ret == 0 {
 		return err
 	}
+	countIconBitmaps(piconinfo.HbmMask, piconinfo.HbmColor)
 	return nil
 }
 
@@ -563,11 +1102,39 @@ func DwmGetWindowAttribute(hwnd windows.HWND, dwAttribute uint32, pvAttribute un
 	return nil
 }
 
+// GetWindowAccentColor returns the caption (or else border) color a window
+// explicitly set through DWM, as a COLORREF (0x00BBGGRR). ok is false when the
+// window uses the default colors or the system doesn't support reading them,
+// which is the case before Windows 11.
+func GetWindowAccentColor(hwnd windows.HWND) (color uint32, ok bool) {
+	for _, attribute := range []uint32{DWMWA_CAPTION_COLOR, DWMWA_BORDER_COLOR} {
+		err := DwmGetWindowAttribute(
+			hwnd,
+			attribute,
+			unsafe.Pointer(&color),
+			uint32(unsafe.Sizeof(color)),
+		)
+		if err == nil && color != DWMWA_COLOR_DEFAULT && color != DWMWA_COLOR_NONE {
+			return color, true
+		}
+	}
+	return 0, false
+}
+
 func DeleteObject(hObject HGDIOBJ) bool {
 	ret, _, _ := procDeleteObject.Call(uintptr(hObject))
 	return ret != 0
 }
 
+// DeleteBitmap is DeleteObject for bitmaps, counted in GetHandleCounts
+func DeleteBitmap(hbm HBITMAP) bool {
+	ok := DeleteObject(HGDIOBJ(hbm))
+	if ok {
+		handleCounts.bitmapsDeleted.Add(1)
+	}
+	return ok
+}
+
 func GetObjectW(hObject HGDIOBJ, cbBuffer int32, lpvObject unsafe.Pointer) int32 {
 	ret, _, _ := procGetObjectW.Call(
 		uintptr(hObject),
@@ -590,6 +1157,73 @@ func ReleaseDC(hwnd windows.HWND, hdc HDC) int32 {
 	return int32(ret)
 }
 
+func CreateCompatibleDC(hdc HDC) HDC {
+	ret, _, _ := procCreateCompatibleDC.Call(uintptr(hdc))
+	return HDC(ret)
+}
+
+func DeleteDC(hdc HDC) bool {
+	ret, _, _ := procDeleteDC.Call(uintptr(hdc))
+	return ret != 0
+}
+
+// CreateCompatibleBitmap creates a bitmap compatible with hdc, counted in
+// GetHandleCounts
+func CreateCompatibleBitmap(hdc HDC, cx, cy int32) HBITMAP {
+	ret, _, _ := procCreateCompatibleBitmap.Call(
+		uintptr(hdc),
+		uintptr(cx),
+		uintptr(cy),
+	)
+	if ret != 0 {
+		handleCounts.bitmapsCreated.Add(1)
+	}
+	return HBITMAP(ret)
+}
+
+// CreateDIBSection creates a DIB whose pixels can be accessed directly
+// through ppvBits, counted in GetHandleCounts
+func CreateDIBSection(hdc HDC, pbmi *BITMAPINFOHEADER, usage uint32, ppvBits *unsafe.Pointer) HBITMAP {
+	ret, _, _ := procCreateDIBSection.Call(
+		uintptr(hdc),
+		uintptr(unsafe.Pointer(pbmi)),
+		uintptr(usage),
+		uintptr(unsafe.Pointer(ppvBits)),
+		0,
+		0,
+	)
+	if ret != 0 {
+		handleCounts.bitmapsCreated.Add(1)
+	}
+	return HBITMAP(ret)
+}
+
+func SelectObject(hdc HDC, h HGDIOBJ) HGDIOBJ {
+	ret, _, _ := procSelectObject.Call(
+		uintptr(hdc),
+		uintptr(h),
+	)
+	return HGDIOBJ(ret)
+}
+
+func DrawIconEx(hdc HDC, xLeft, yTop int32, hIcon HICON, cxWidth, cyWidth int32, istepIfAniCur uint32, hbrFlickerFreeDraw HANDLE, diFlags uint32) error {
+	ret, _, err := procDrawIconEx.Call(
+		uintptr(hdc),
+		uintptr(xLeft),
+		uintptr(yTop),
+		uintptr(hIcon),
+		uintptr(cxWidth),
+		uintptr(cyWidth),
+		uintptr(istepIfAniCur),
+		uintptr(hbrFlickerFreeDraw),
+		uintptr(diFlags),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
 func GetDIBits(hdc HDC, hbmp HBITMAP, uStartScan uint32, cScanLines uint32, lpvBits unsafe.Pointer, lpbi *BITMAPINFOHEADER, uUsage uint32) int32 {
 	ret, _, _ := procGetDIBits.Call(
 		uintptr(hdc),
@@ -606,22 +1240,36 @@ func GetDIBits(hdc HDC, hbmp HBITMAP, uStartScan uint32, cScanLines uint32, lpvB
 // WindowsClassNamesToSkip defines window classes that should not be activated
 var WindowsClassNamesToSkip = []string{
 	"Shell_TrayWnd",
+	// The taskbars on monitors other than the main one
+	"Shell_SecondaryTrayWnd",
+	// The desktop, which animated wallpaper apps can make look like a normal
+	// window
+	"Progman",
+	"WorkerW",
 	"DV2ControlHost",
 	"MsgrIMEWindowClass",
 	"SysShadow",
 	"Button",
+	// The hidden console Windows Terminal and other ConPTY hosts create for
+	// each shell, which is owned by the terminal's own window
+	"PseudoConsoleWindow",
 }
 
 // GetLastVisibleActivePopUpOfWindow finds the last visible active popup of a window
 func GetLastVisibleActivePopUpOfWindow(hwnd windows.HWND) windows.HWND {
+	return LastVisibleActivePopUp(SystemWindows, hwnd)
+}
+
+// LastVisibleActivePopUp is GetLastVisibleActivePopUpOfWindow against any WindowProvider
+func LastVisibleActivePopUp(p WindowProvider, hwnd windows.HWND) windows.HWND {
 	level := MaxLastActivePopupIterations
 	currentWindow := hwnd
 
 	for level > 0 {
 		level--
-		lastPopUp := GetLastActivePopup(currentWindow)
+		lastPopUp := p.LastActivePopup(currentWindow)
 
-		if windows.IsWindowVisible(lastPopUp) {
+		if p.IsWindowVisible(lastPopUp) {
 			return lastPopUp
 		}
 
@@ -638,31 +1286,33 @@ func GetLastVisibleActivePopUpOfWindow(hwnd windows.HWND) windows.HWND {
 // EligibleForActivation determines if a window is eligible for activation
 // Based on: http://stackoverflow.com/questions/210504/enumerate-windows-like-alt-tab-does
 func EligibleForActivation(hwnd windows.HWND, shellWindow windows.HWND) bool {
+	return IsEligibleForActivation(SystemWindows, hwnd, shellWindow)
+}
+
+// IsEligibleForActivation is EligibleForActivation against any WindowProvider
+func IsEligibleForActivation(p WindowProvider, hwnd windows.HWND, shellWindow windows.HWND) bool {
 	if hwnd == shellWindow {
 		return false
 	}
 
-	root := GetAncestor(hwnd, GA_ROOTOWNER)
+	root := p.Ancestor(hwnd, GA_ROOTOWNER)
 
-	if GetLastVisibleActivePopUpOfWindow(root) != hwnd {
+	if LastVisibleActivePopUp(p, root) != hwnd {
 		return false
 	}
 
-	className := make([]uint16, 256)
-	length, err := GetClassNameW(hwnd, &className[0], int32(len(className)))
-	if err != nil || length == 0 {
+	classNameStr, err := p.ClassName(hwnd)
+	if err != nil || classNameStr == "" {
 		return false
 	}
 
-	classNameStr := windows.UTF16ToString(className)
-
 	// Check if class name is in the skip list
 	if slices.Contains(WindowsClassNamesToSkip, classNameStr) {
 		return false
 	}
 
 	// Check for WMP9MediaBarFlyout (Windows Media Player's "now playing" taskbar-toolbar)
-	if len(classNameStr) >= 18 && classNameStr[:18] == "WMP9MediaBarFlyout" {
+	if strings.HasPrefix(classNameStr, "WMP9MediaBarFlyout") {
 		return false
 	}
 
@@ -672,31 +1322,62 @@ func EligibleForActivation(hwnd windows.HWND, shellWindow windows.HWND) bool {
 // IsAltTabWindow determines if a window should appear in Alt+Tab
 // This is a more modern approach that includes DWM cloaking detection
 func IsAltTabWindow(hwnd windows.HWND) bool {
-	// The window must be visible
-	if !windows.IsWindowVisible(hwnd) {
+	return IsAltTabWindowFor(SystemWindows, hwnd)
+}
+
+// IsAltTabWindowFor is IsAltTabWindow against any WindowProvider
+func IsAltTabWindowFor(p WindowProvider, hwnd windows.HWND) bool {
+	eligible := AltTabEligible(AltTabAttributes{
+		Visible:     p.IsWindowVisible(hwnd),
+		Cloaked:     p.Cloaked(hwnd),
+		ExStyle:     p.ExStyle(hwnd),
+		IsRootOwner: p.Ancestor(hwnd, GA_ROOTOWNER) == hwnd,
+	})
+	if !eligible {
 		return false
 	}
 
-	// The window must be a root owner
-	if GetAncestor(hwnd, GA_ROOTOWNER) != hwnd {
+	// Shell windows that pass the style checks but aren't apps
+	className, err := p.ClassName(hwnd)
+	return err != nil || !slices.Contains(WindowsClassNamesToSkip, className)
+}
+
+// AltTabAttributes are the window properties AltTabEligible decides on
+type AltTabAttributes struct {
+	Visible bool
+	// Cloaked is the window's DWMWA_CLOAKED value
+	Cloaked uint32
+	ExStyle uintptr
+	// IsRootOwner is set when the window isn't owned by another window
+	IsRootOwner bool
+}
+
+// AltTabEligible is the decision made by IsAltTabWindow, from attributes
+// already read from the window
+func AltTabEligible(a AltTabAttributes) bool {
+	// The window must be visible
+	if !a.Visible {
 		return false
 	}
 
 	// The window must not be cloaked by the shell
-	var cloaked uint32
-	err := DwmGetWindowAttribute(
-		hwnd,
-		DWMWA_CLOAKED,
-		unsafe.Pointer(&cloaked),
-		uint32(unsafe.Sizeof(cloaked)),
-	)
-	if err == nil && cloaked == DWM_CLOAKED_SHELL {
+	if a.Cloaked == DWM_CLOAKED_SHELL {
+		return false
+	}
+
+	// WS_EX_APPWINDOW forces a window onto the taskbar and into Alt+Tab, even
+	// when it's owned or a tool window
+	if (a.ExStyle & WS_EX_APPWINDOW) != 0 {
+		return true
+	}
+
+	// Otherwise the window must be a root owner
+	if !a.IsRootOwner {
 		return false
 	}
 
-	// The window must not have the extended style WS_EX_TOOLWINDOW
-	exStyle := GetWindowLongPtrW(hwnd, GWL_EXSTYLE)
-	if (exStyle & WS_EX_TOOLWINDOW) != 0 {
+	// and must not have the extended style WS_EX_TOOLWINDOW
+	if (a.ExStyle & WS_EX_TOOLWINDOW) != 0 {
 		return false
 	}
 
@@ -706,79 +1387,107 @@ func IsAltTabWindow(hwnd windows.HWND) bool {
 type IconInfo struct {
 	Icon   HICON
 	Source string
+	// Owned is set when the icon was created for us (ExtractIconEx) and must
+	// be freed with DestroyIcon, rather than borrowed from the window
+	Owned bool
 }
 
-func GetWindowIcon(hwnd windows.HWND, exePath string) IconInfo {
-	// Try WM_GETICON first
-	icon := SendMessage(
-		hwnd,
-		WM_GETICON,
-		ICON_BIG,
-		0,
-	)
-	if icon != 0 {
-		return IconInfo{
-			Icon:   HICON(icon),
-			Source: "WM_GETICON",
-		}
+// IconResourceIndex returns the ExtractIconExW index of the icon resource
+// icon was loaded from, which is its negated resource ID. ok is false when
+// icon didn't come from modulePath or was loaded by name rather than ID.
+func IconResourceIndex(icon HICON, modulePath string) (index int32, ok bool) {
+	var info ICONINFOEXW
+	if err := GetIconInfoExW(icon, &info); err != nil {
+		return 0, false
 	}
-
-	icon = SendMessage(
-		hwnd,
-		WM_GETICON,
-		ICON_SMALL,
-		0,
-	)
-	if icon != 0 {
-		return IconInfo{
-			Icon:   HICON(icon),
-			Source: "WM_GETICON_S",
+	for _, hbm := range []HBITMAP{info.HbmMask, info.HbmColor} {
+		if hbm != 0 {
+			DeleteBitmap(hbm)
 		}
 	}
 
-	icon = SendMessage(
-		hwnd,
-		WM_GETICON,
-		ICON_SMALL2,
-		0,
-	)
-	if icon != 0 {
-		return IconInfo{
-			Icon:   HICON(icon),
-			Source: "WM_GETICON_S2",
-		}
+	if info.WResID == 0 || !strings.EqualFold(windows.UTF16ToString(info.SzModName[:]), modulePath) {
+		return 0, false
 	}
+	return -int32(info.WResID), true
+}
 
-	// Try getting icon from window class
-	ret, _ := GetClassLongPtrW(hwnd, GCLP_HICON)
-	if ret != 0 {
-		return IconInfo{
-			Icon:   HICON(ret),
-			Source: "GCLP_HICON",
-		}
+// largeIconFromResource extracts the large version of a small icon from the
+// executable it was loaded from, since a small icon scaled up looks blurry
+func largeIconFromResource(small HICON, exePath string) (IconInfo, bool) {
+	if exePath == "" {
+		return IconInfo{}, false
 	}
+	index, ok := IconResourceIndex(small, exePath)
+	if !ok {
+		return IconInfo{}, false
+	}
+	exePathUTF16, err := windows.UTF16PtrFromString(exePath)
+	if err != nil {
+		return IconInfo{}, false
+	}
+	var largeIcon HICON
+	if ExtractIconExW(exePathUTF16, index, &largeIcon, nil, 1) == 0 || largeIcon == 0 {
+		return IconInfo{}, false
+	}
+	return IconInfo{Icon: largeIcon, Source: "ExtractIconEx_res", Owned: true}, true
+}
 
-	ret, _ = GetClassLongPtrW(hwnd, GCLP_HICONSM)
-	if ret != 0 {
-		return IconInfo{
-			Icon:   HICON(ret),
-			Source: "GCLP_HICONSM",
-		}
+// Icon sources GetWindowIcon can try, also reported as IconInfo.Source
+const (
+	IconSourceBig        = "WM_GETICON"
+	IconSourceSmall      = "WM_GETICON_S"
+	IconSourceSmall2     = "WM_GETICON_S2"
+	IconSourceClass      = "GCLP_HICON"
+	IconSourceClassSmall = "GCLP_HICONSM"
+	IconSourceExe        = "ExtractIconEx"
+)
+
+// DefaultIconSources is the order GetWindowIcon tries the icon sources in
+// unless told otherwise: the window's own icons, then its class's, then the
+// executable's
+var DefaultIconSources = []string{
+	IconSourceBig,
+	IconSourceSmall,
+	IconSourceSmall2,
+	IconSourceClass,
+	IconSourceClassSmall,
+	IconSourceExe,
+}
+
+// GetWindowIcon finds the best icon for hwnd, trying sources (IconSource*
+// values, DefaultIconSources when empty) in order and falling back to the
+// default application icon. Unknown sources are skipped. Small icons that
+// were loaded from exePath are swapped for their large version.
+//
+// iconIndex picks the icon that the ExtractIconEx source takes from exePath,
+// in ExtractIconExW's format (a zero-based index, or a negated resource ID);
+// index 0 is tried when it doesn't exist.
+func GetWindowIcon(hwnd windows.HWND, exePath string, iconIndex int32, sources []string) IconInfo {
+	if len(sources) == 0 {
+		sources = DefaultIconSources
 	}
+	return firstUsableIcon(sources, func(source string) (IconInfo, bool) {
+		return iconFromSource(source, hwnd, exePath, iconIndex)
+	})
+}
 
-	// Try to extract icon from the executable path if provided
-	if exePath != "" {
-		exePathUTF16, err := windows.UTF16PtrFromString(exePath)
-		if err == nil {
-			var largeIcon HICON
-			numIcons := ExtractIconExW(exePathUTF16, 0, &largeIcon, nil, 1)
-			if numIcons > 0 && largeIcon != 0 {
-				return IconInfo{
-					Icon:   largeIcon,
-					Source: "ExtractIconEx",
-				}
+// firstUsableIcon returns the first icon load gets from sources that isn't
+// degenerate, or the default application icon
+func firstUsableIcon(sources []string, load func(source string) (IconInfo, bool)) IconInfo {
+	for _, source := range sources {
+		info, ok := load(source)
+		if !ok {
+			continue
+		}
+		// Some windows hand out 1x1 or similarly useless placeholders
+		if degenerateIcon(info.Icon) {
+			if info.Owned {
+				DestroyIcon(info.Icon)
 			}
+			continue
 		}
+		return info
 	}
 
 	// Fall back to default system icon
@@ -788,30 +1497,109 @@ func GetWindowIcon(hwnd windows.HWND, exePath string) IconInfo {
 	}
 }
 
+// minIconSize is the smallest icon GetWindowIcon accepts, in either dimension
+const minIconSize = 8
+
+// degenerateIcon reports whether icon is smaller than minIconSize. An icon
+// whose size can't be read isn't known to be a placeholder, so it isn't one.
+func degenerateIcon(icon HICON) bool {
+	var iconInfo ICONINFO
+	if err := GetIconInfo(icon, &iconInfo); err != nil {
+		return false
+	}
+	defer DeleteBitmap(iconInfo.HbmMask)
+	if iconInfo.HbmColor != 0 {
+		defer DeleteBitmap(iconInfo.HbmColor)
+	}
+
+	width, height, err := iconSize(iconInfo)
+	return err == nil && (width < minIconSize || height < minIconSize)
+}
+
+// iconFromSource gets hwnd's icon from one of the IconSource* sources
+func iconFromSource(source string, hwnd windows.HWND, exePath string, iconIndex int32) (IconInfo, bool) {
+	var icon uintptr
+	small := false
+	switch source {
+	case IconSourceBig:
+		icon = uintptr(SendMessage(hwnd, WM_GETICON, ICON_BIG, 0))
+	case IconSourceSmall:
+		icon = uintptr(SendMessage(hwnd, WM_GETICON, ICON_SMALL, 0))
+		small = true
+	case IconSourceSmall2:
+		icon = uintptr(SendMessage(hwnd, WM_GETICON, ICON_SMALL2, 0))
+		small = true
+	case IconSourceClass:
+		icon, _ = GetClassLongPtrW(hwnd, GCLP_HICON)
+	case IconSourceClassSmall:
+		icon, _ = GetClassLongPtrW(hwnd, GCLP_HICONSM)
+		small = true
+	case IconSourceExe:
+		return iconFromExe(exePath, iconIndex)
+	}
+	if icon == 0 {
+		return IconInfo{}, false
+	}
+
+	if small {
+		if large, ok := largeIconFromResource(HICON(icon), exePath); ok {
+			return large, true
+		}
+	}
+	return IconInfo{Icon: HICON(icon), Source: source}, true
+}
+
+// iconFromExe extracts the large icon at iconIndex from exePath
+func iconFromExe(exePath string, iconIndex int32) (IconInfo, bool) {
+	if exePath == "" {
+		return IconInfo{}, false
+	}
+	exePathUTF16, err := windows.UTF16PtrFromString(exePath)
+	if err != nil {
+		return IconInfo{}, false
+	}
+	var largeIcon HICON
+	numIcons := ExtractIconExW(exePathUTF16, iconIndex, &largeIcon, nil, 1)
+	if (numIcons == 0 || largeIcon == 0) && iconIndex != 0 {
+		numIcons = ExtractIconExW(exePathUTF16, 0, &largeIcon, nil, 1)
+	}
+	if numIcons == 0 || largeIcon == 0 {
+		return IconInfo{}, false
+	}
+	return IconInfo{Icon: largeIcon, Source: IconSourceExe, Owned: true}, true
+}
+
+// GetForegroundWindow returns the foreground window, or 0 while the
+// foreground is changing. It has no failure to report.
 func GetForegroundWindow() windows.HWND {
 	ret, _, _ := procGetForegroundWindow.Call()
 	return windows.HWND(ret)
 }
 
+// SetForegroundWindow reports whether the window was brought to the
+// foreground. It doesn't set a last error when refused.
 func SetForegroundWindow(hwnd windows.HWND) bool {
 	ret, _, _ := procSetForegroundWindow.Call(uintptr(hwnd))
 	return ret != 0
 }
 
-func GetWindowThreadProcessId(hwnd windows.HWND, lpdwProcessId *DWORD) DWORD {
-	ret, _, _ := procGetWindowThreadProcessId.Call(
-		uintptr(hwnd),
-		uintptr(unsafe.Pointer(lpdwProcessId)),
-	)
-	return DWORD(ret)
+func BringWindowToTop(hwnd windows.HWND) error {
+	ret, _, err := procBringWindowToTop.Call(uintptr(hwnd))
+	if ret == 0 {
+		return err
+	}
+	return nil
 }
 
-func QueryFullProcessImageNameW(hProcess windows.Handle, dwFlags DWORD, lpExeName *uint16, lpdwSize *DWORD) error {
-	ret, _, err := procQueryFullProcessImageNameW.Call(
-		uintptr(hProcess),
-		uintptr(dwFlags),
-		uintptr(unsafe.Pointer(lpExeName)),
-		uintptr(unsafe.Pointer(lpdwSize)),
+func SetWindowPos(hwnd windows.HWND, hwndInsertAfter windows.HWND, x, y, cx, cy int32, uFlags uint32) error {
+	ret, _, err := procSetWindowPos.Call(
+		uintptr(hwnd),
+		uintptr(hwndInsertAfter),
+		uintptr(x),
+		uintptr(y),
+		uintptr(cx),
+		uintptr(cy),
+		uintptr(uFlags),
 	)
 	if ret == 0 {
 		return err
@@ -819,96 +1607,1339 @@ func QueryFullProcessImageNameW(hProcess windows.Handle, dwFlags DWORD, lpExeNam
 	return nil
 }
 
-func ExtractIconExW(lpszFile *uint16, nIconIndex int32, phiconLarge *HICON, phiconSmall *HICON, nIcons uint32) uint32 {
-	ret, _, _ := procExtractIconExW.Call(
+func GetWindow(hwnd windows.HWND, uCmd uint32) windows.HWND {
+	ret, _, _ := procGetWindow.Call(
+		uintptr(hwnd),
+		uintptr(uCmd),
+	)
+	return windows.HWND(ret)
+}
+
+func SendInput(inputs []KEYBOARDINPUT) (uint32, error) {
+	if len(inputs) == 0 {
+		return 0, nil
+	}
+	ret, _, err := procSendInput.Call(
+		uintptr(len(inputs)),
+		uintptr(unsafe.Pointer(&inputs[0])),
+		unsafe.Sizeof(inputs[0]),
+	)
+	if ret == 0 {
+		return 0, err
+	}
+	return uint32(ret), nil
+}
+
+// GetLastInputInfo returns the tick count of the last input event in the session
+func GetLastInputInfo() (uint32, error) {
+	info := LASTINPUTINFO{CbSize: DWORD(unsafe.Sizeof(LASTINPUTINFO{}))}
+	ret, _, err := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info)))
+	if ret == 0 {
+		return 0, err
+	}
+	return uint32(info.DwTime), nil
+}
+
+func GetTickCount() uint32 {
+	ret, _, _ := procGetTickCount.Call()
+	return uint32(ret)
+}
+
+func PostThreadMessageW(idThread uint32, msg uint32, wParam WPARAM, lParam LPARAM) error {
+	ret, _, err := procPostThreadMessageW.Call(
+		uintptr(idThread),
+		uintptr(msg),
+		uintptr(wParam),
+		uintptr(lParam),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// RegisterHotKey registers a system-wide hotkey. With hwnd 0, WM_HOTKEY is
+// posted to the calling thread's message queue.
+func RegisterHotKey(hwnd windows.HWND, id int32, fsModifiers uint32, vk uint32) error {
+	ret, _, err := procRegisterHotKey.Call(
+		uintptr(hwnd),
+		uintptr(id),
+		uintptr(fsModifiers),
+		uintptr(vk),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func UnregisterHotKey(hwnd windows.HWND, id int32) error {
+	ret, _, err := procUnregisterHotKey.Call(
+		uintptr(hwnd),
+		uintptr(id),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func OpenClipboard(hwndNewOwner windows.HWND) error {
+	ret, _, err := procOpenClipboard.Call(uintptr(hwndNewOwner))
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func CloseClipboard() error {
+	ret, _, err := procCloseClipboard.Call()
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func EmptyClipboard() error {
+	ret, _, err := procEmptyClipboard.Call()
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// SetClipboardData puts hMem on the open clipboard. On success the clipboard
+// owns hMem, and it must no longer be used or freed.
+func SetClipboardData(uFormat uint32, hMem HANDLE) error {
+	ret, _, err := procSetClipboardData.Call(uintptr(uFormat), uintptr(hMem))
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func GlobalAlloc(uFlags uint32, dwBytes uintptr) (HANDLE, error) {
+	ret, _, err := procGlobalAlloc.Call(uintptr(uFlags), dwBytes)
+	if ret == 0 {
+		return 0, err
+	}
+	return HANDLE(ret), nil
+}
+
+func GlobalFree(hMem HANDLE) {
+	procGlobalFree.Call(uintptr(hMem))
+}
+
+// GlobalLock returns the address of the locked memory. It's kept a uintptr,
+// since the memory isn't Go's: copy into it with MoveMemory.
+func GlobalLock(hMem HANDLE) (uintptr, error) {
+	ret, _, err := procGlobalLock.Call(uintptr(hMem))
+	if ret == 0 {
+		return 0, err
+	}
+	return ret, nil
+}
+
+func GlobalUnlock(hMem HANDLE) {
+	procGlobalUnlock.Call(uintptr(hMem))
+}
+
+// MoveMemory copies size bytes from src to the memory at dest
+func MoveMemory(dest uintptr, src unsafe.Pointer, size uintptr) {
+	procRtlMoveMemory.Call(dest, uintptr(src), size)
+}
+
+// clipboardOpenAttempts and clipboardRetryDelay bound how long
+// SetClipboardText waits for another app to close the clipboard
+const (
+	clipboardOpenAttempts = 5
+	clipboardRetryDelay   = 20 * time.Millisecond
+)
+
+// SetClipboardText replaces the clipboard contents with text. Only one app
+// can have the clipboard open at a time, and clipboard managers open it right
+// after every change, so opening it is retried a few times.
+func SetClipboardText(text string) error {
+	utf16Text, err := windows.UTF16FromString(text)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = OpenClipboard(0)
+		if err == nil {
+			break
+		}
+		if attempt == clipboardOpenAttempts {
+			return fmt.Errorf("OpenClipboard failed: %w", err)
+		}
+		time.Sleep(clipboardRetryDelay)
+	}
+	defer CloseClipboard()
+
+	if err := EmptyClipboard(); err != nil {
+		return fmt.Errorf("EmptyClipboard failed: %w", err)
+	}
+
+	size := uintptr(len(utf16Text)) * unsafe.Sizeof(utf16Text[0])
+	mem, err := GlobalAlloc(GMEM_MOVEABLE, size)
+	if err != nil {
+		return fmt.Errorf("GlobalAlloc failed: %w", err)
+	}
+	ptr, err := GlobalLock(mem)
+	if err != nil {
+		GlobalFree(mem)
+		return fmt.Errorf("GlobalLock failed: %w", err)
+	}
+	MoveMemory(ptr, unsafe.Pointer(&utf16Text[0]), size)
+	GlobalUnlock(mem)
+
+	if err := SetClipboardData(CF_UNICODETEXT, mem); err != nil {
+		// The clipboard only takes ownership of the memory on success
+		GlobalFree(mem)
+		return fmt.Errorf("SetClipboardData failed: %w", err)
+	}
+	return nil
+}
+
+// SwitchToThisWindow activates hwnd the way Alt+Tab does, which includes
+// switching to the virtual desktop the window is on
+func SwitchToThisWindow(hwnd windows.HWND, fUnknown bool) {
+	altTab := uintptr(0)
+	if fUnknown {
+		altTab = 1
+	}
+	procSwitchToThisWindow.Call(uintptr(hwnd), altTab)
+}
+
+func GetWindowPlacement(hwnd windows.HWND, lpwndpl *WINDOWPLACEMENT) error {
+	lpwndpl.Length = uint32(unsafe.Sizeof(*lpwndpl))
+	ret, _, err := procGetWindowPlacement.Call(
+		uintptr(hwnd),
+		uintptr(unsafe.Pointer(lpwndpl)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func SetWindowPlacement(hwnd windows.HWND, lpwndpl *WINDOWPLACEMENT) error {
+	lpwndpl.Length = uint32(unsafe.Sizeof(*lpwndpl))
+	ret, _, err := procSetWindowPlacement.Call(
+		uintptr(hwnd),
+		uintptr(unsafe.Pointer(lpwndpl)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// ShowWindow sets the show state of hwnd and reports whether it was visible
+// before
+func ShowWindow(hwnd windows.HWND, nCmdShow int32) bool {
+	ret, _, _ := procShowWindow.Call(
+		uintptr(hwnd),
+		uintptr(nCmdShow),
+	)
+	return ret != 0
+}
+
+func MonitorFromRect(lprc *RECT, dwFlags uint32) HANDLE {
+	ret, _, _ := procMonitorFromRect.Call(
+		uintptr(unsafe.Pointer(lprc)),
+		uintptr(dwFlags),
+	)
+	return HANDLE(ret)
+}
+
+func MonitorFromWindow(hwnd windows.HWND, dwFlags uint32) HANDLE {
+	ret, _, _ := procMonitorFromWindow.Call(
+		uintptr(hwnd),
+		uintptr(dwFlags),
+	)
+	return HANDLE(ret)
+}
+
+func GetCursorPos(lpPoint *POINT) error {
+	ret, _, err := procGetCursorPos.Call(
+		uintptr(unsafe.Pointer(lpPoint)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func SetCursorPos(x, y int32) error {
+	ret, _, err := procSetCursorPos.Call(
+		uintptr(x),
+		uintptr(y),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// WindowFromPoint returns the window containing pt, which may be a child
+// window. The POINT is passed by value, packed into a single register.
+func WindowFromPoint(pt POINT) windows.HWND {
+	ret, _, _ := procWindowFromPoint.Call(
+		uintptr(uint32(pt.X)) | uintptr(uint32(pt.Y))<<32,
+	)
+	return windows.HWND(ret)
+}
+
+// USER_DEFAULT_SCREEN_DPI is the DPI of a monitor at 100% scaling
+const USER_DEFAULT_SCREEN_DPI = 96
+
+// GetDpiForWindow returns the DPI of the monitor hwnd is on, or 0 before
+// Windows 10 1607, which doesn't have the function
+func GetDpiForWindow(hwnd windows.HWND) uint32 {
+	if procGetDpiForWindow.Find() != nil {
+		return 0
+	}
+	ret, _, _ := procGetDpiForWindow.Call(uintptr(hwnd))
+	return uint32(ret)
+}
+
+// GetDpiForMonitor returns the effective horizontal and vertical DPI of
+// hMonitor
+func GetDpiForMonitor(hMonitor HANDLE) (uint32, uint32, error) {
+	if err := procGetDpiForMonitor.Find(); err != nil {
+		return 0, 0, err
+	}
+	var dpiX, dpiY uint32
+	ret, _, _ := procGetDpiForMonitor.Call(
+		uintptr(hMonitor),
+		MDT_EFFECTIVE_DPI,
+		uintptr(unsafe.Pointer(&dpiX)),
+		uintptr(unsafe.Pointer(&dpiY)),
+	)
+	if ret != 0 {
+		return 0, 0, syscall.Errno(ret)
+	}
+	return dpiX, dpiY, nil
+}
+
+// GetWindowDpi returns the DPI of the monitor hwnd is on. GetDpiForMonitor
+// stands in for GetDpiForWindow on Windows 8.1 and early Windows 10, and
+// USER_DEFAULT_SCREEN_DPI is assumed where neither exists.
+func GetWindowDpi(hwnd windows.HWND) uint32 {
+	if dpi := GetDpiForWindow(hwnd); dpi != 0 {
+		return dpi
+	}
+	monitor := MonitorFromWindow(hwnd, MONITOR_DEFAULTTONEAREST)
+	if dpi, _, err := GetDpiForMonitor(monitor); err == nil && dpi != 0 {
+		return dpi
+	}
+	return USER_DEFAULT_SCREEN_DPI
+}
+
+func GetMonitorInfoW(hMonitor HANDLE, lpmi *MONITORINFO) error {
+	lpmi.CbSize = uint32(unsafe.Sizeof(*lpmi))
+	ret, _, err := procGetMonitorInfoW.Call(
+		uintptr(hMonitor),
+		uintptr(unsafe.Pointer(lpmi)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func EnumDisplayMonitors(hdc HDC, lprcClip *RECT, lpfnEnum MONITORENUMPROC, lParam LPARAM) error {
+	ret, _, err := procEnumDisplayMonitors.Call(
+		uintptr(hdc),
+		uintptr(unsafe.Pointer(lprcClip)),
+		syscall.NewCallback(lpfnEnum),
+		uintptr(lParam),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// monitorEnumerations holds the work areas collected by each running
+// MonitorWorkAreas call. The callback is handed the key of its call's entry
+// as its LPARAM rather than a pointer to the slice, which would be a Go
+// pointer smuggled through an integer.
+var monitorEnumerations = struct {
+	sync.Mutex
+	next  LPARAM
+	areas map[LPARAM][]RECT
+}{areas: map[LPARAM][]RECT{}}
+
+func monitorWorkAreasCallback(hMonitor HANDLE, hdc HDC, lprcMonitor *RECT, lParam LPARAM) (ret uintptr) {
+	defer func() {
+		if r := recover(); r != nil {
+			LogCallbackPanic("monitor enumeration", r)
+			ret = 1
+		}
+	}()
+
+	var info MONITORINFO
+	if err := GetMonitorInfoW(hMonitor, &info); err != nil {
+		// The monitor was disconnected while enumerating
+		return 1
+	}
+	monitorEnumerations.Lock()
+	defer monitorEnumerations.Unlock()
+	monitorEnumerations.areas[lParam] = append(monitorEnumerations.areas[lParam], info.RcWork)
+	return 1
+}
+
+// MonitorWorkAreas returns the work area, the part not covered by the taskbar
+// and docked toolbars, of every monitor
+func MonitorWorkAreas() ([]RECT, error) {
+	monitorEnumerations.Lock()
+	monitorEnumerations.next++
+	key := monitorEnumerations.next
+	monitorEnumerations.Unlock()
+
+	err := EnumDisplayMonitors(0, nil, (MONITORENUMPROC)(monitorWorkAreasCallback), key)
+
+	monitorEnumerations.Lock()
+	defer monitorEnumerations.Unlock()
+	areas := monitorEnumerations.areas[key]
+	delete(monitorEnumerations.areas, key)
+	return areas, err
+}
+
+// ClampRect moves rect so that it lies within bounds, shrinking it if it's
+// larger than bounds
+func ClampRect(rect RECT, bounds RECT) RECT {
+	width := min(rect.Right-rect.Left, bounds.Right-bounds.Left)
+	height := min(rect.Bottom-rect.Top, bounds.Bottom-bounds.Top)
+
+	left := max(bounds.Left, min(rect.Left, bounds.Right-width))
+	top := max(bounds.Top, min(rect.Top, bounds.Bottom-height))
+	return RECT{Left: left, Top: top, Right: left + width, Bottom: top + height}
+}
+
+// IntersectRects returns the overlap of a and b, and false when they don't
+// overlap
+func IntersectRects(a, b RECT) (RECT, bool) {
+	rect := RECT{
+		Left:   max(a.Left, b.Left),
+		Top:    max(a.Top, b.Top),
+		Right:  min(a.Right, b.Right),
+		Bottom: min(a.Bottom, b.Bottom),
+	}
+	if rect.Left >= rect.Right || rect.Top >= rect.Bottom {
+		return RECT{}, false
+	}
+	return rect, true
+}
+
+func GetModuleHandleW(lpModuleName *uint16) HINSTANCE {
+	ret, _, _ := procGetModuleHandleW.Call(uintptr(unsafe.Pointer(lpModuleName)))
+	return HINSTANCE(ret)
+}
+
+func RegisterClassExW(wc *WNDCLASSEXW) (uint16, error) {
+	wc.CbSize = uint32(unsafe.Sizeof(*wc))
+	ret, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(wc)))
+	if ret == 0 {
+		return 0, err
+	}
+	return uint16(ret), nil
+}
+
+func CreateWindowExW(dwExStyle uint32, lpClassName, lpWindowName *uint16, dwStyle uint32, x, y, width, height int32, hWndParent windows.HWND, hMenu HANDLE, hInstance HINSTANCE, lpParam uintptr) (windows.HWND, error) {
+	ret, _, err := procCreateWindowExW.Call(
+		uintptr(dwExStyle),
+		uintptr(unsafe.Pointer(lpClassName)),
+		uintptr(unsafe.Pointer(lpWindowName)),
+		uintptr(dwStyle),
+		uintptr(x),
+		uintptr(y),
+		uintptr(width),
+		uintptr(height),
+		uintptr(hWndParent),
+		uintptr(hMenu),
+		uintptr(hInstance),
+		lpParam,
+	)
+	if ret == 0 {
+		return 0, err
+	}
+	return windows.HWND(ret), nil
+}
+
+func DestroyWindow(hwnd windows.HWND) error {
+	ret, _, err := procDestroyWindow.Call(uintptr(hwnd))
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func DefWindowProcW(hwnd windows.HWND, msg uint32, wParam WPARAM, lParam LPARAM) LRESULT {
+	ret, _, _ := procDefWindowProcW.Call(
+		uintptr(hwnd),
+		uintptr(msg),
+		uintptr(wParam),
+		uintptr(lParam),
+	)
+	return LRESULT(ret)
+}
+
+func RegisterWindowMessageW(lpString *uint16) (uint32, error) {
+	ret, _, err := procRegisterWindowMessageW.Call(uintptr(unsafe.Pointer(lpString)))
+	if ret == 0 {
+		return 0, err
+	}
+	return uint32(ret), nil
+}
+
+func RegisterShellHookWindow(hwnd windows.HWND) error {
+	ret, _, err := procRegisterShellHookWindow.Call(uintptr(hwnd))
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func DeregisterShellHookWindow(hwnd windows.HWND) bool {
+	ret, _, _ := procDeregisterShellHookWindow.Call(uintptr(hwnd))
+	return ret != 0
+}
+
+func GetWindowThreadProcessId(hwnd windows.HWND, lpdwProcessId *DWORD) DWORD {
+	ret, _, _ := procGetWindowThreadProcessId.Call(
+		uintptr(hwnd),
+		uintptr(unsafe.Pointer(lpdwProcessId)),
+	)
+	return DWORD(ret)
+}
+
+// GetAsyncKeyState reports whether the given virtual key is currently held down
+func GetAsyncKeyState(vKey int32) bool {
+	ret, _, _ := procGetAsyncKeyState.Call(uintptr(vKey))
+	// The most significant bit of the SHORT result is set while the key is down
+	return uint16(ret)&0x8000 != 0
+}
+
+func QueryFullProcessImageNameW(hProcess windows.Handle, dwFlags DWORD, lpExeName *uint16, lpdwSize *DWORD) error {
+	ret, _, err := procQueryFullProcessImageNameW.Call(
+		uintptr(hProcess),
+		uintptr(dwFlags),
+		uintptr(unsafe.Pointer(lpExeName)),
+		uintptr(unsafe.Pointer(lpdwSize)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// GetTokenIntegrityLevel returns the integrity level of token, one of the
+// SECURITY_MANDATORY_*_RID values
+func GetTokenIntegrityLevel(token windows.Token) (uint32, error) {
+	var size uint32
+	windows.GetTokenInformation(token, windows.TokenIntegrityLevel, nil, 0, &size)
+	if size == 0 {
+		return 0, fmt.Errorf("GetTokenInformation returned no size")
+	}
+	buf := make([]byte, size)
+	if err := windows.GetTokenInformation(token, windows.TokenIntegrityLevel, &buf[0], size, &size); err != nil {
+		return 0, err
+	}
+
+	sid := (*windows.Tokenmandatorylabel)(unsafe.Pointer(&buf[0])).Label.Sid
+	count := sid.SubAuthorityCount()
+	if count == 0 {
+		return 0, fmt.Errorf("integrity SID has no sub-authority")
+	}
+	return sid.SubAuthority(uint32(count - 1)), nil
+}
+
+// GetProcessCommandLine returns the command line process pid was started
+// with. ProcessCommandLineInformation (Windows 8.1 and later) only needs
+// limited query access; before that the command line is read out of the
+// process's PEB, which also needs PROCESS_VM_READ.
+func GetProcessCommandLine(pid uint32) (string, error) {
+	hProcess, err := windows.OpenProcess(PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(hProcess)
+
+	commandLine, err := queryCommandLine(hProcess)
+	if err == nil {
+		return commandLine, nil
+	}
+
+	hProcess, err = windows.OpenProcess(windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(hProcess)
+	return readPEBCommandLine(hProcess)
+}
+
+// queryCommandLine asks for the command line through
+// ProcessCommandLineInformation, which returns it as a UNICODE_STRING
+// followed by its characters
+func queryCommandLine(hProcess windows.Handle) (string, error) {
+	var size uint32
+	err := windows.NtQueryInformationProcess(hProcess, windows.ProcessCommandLineInformation, nil, 0, &size)
+	if err != windows.STATUS_INFO_LENGTH_MISMATCH {
+		if err == nil {
+			err = windows.STATUS_INFO_LENGTH_MISMATCH
+		}
+		return "", err
+	}
+
+	// Allocated as uintptrs to keep the UNICODE_STRING at the start aligned
+	buf := make([]uintptr, (uintptr(size)+unsafe.Sizeof(uintptr(0))-1)/unsafe.Sizeof(uintptr(0)))
+	err = windows.NtQueryInformationProcess(hProcess, windows.ProcessCommandLineInformation,
+		unsafe.Pointer(&buf[0]), size, &size)
+	if err != nil {
+		return "", err
+	}
+	return (*windows.NTUnicodeString)(unsafe.Pointer(&buf[0])).String(), nil
+}
+
+// readPEBCommandLine copies the command line out of the process parameters
+// its PEB points to
+func readPEBCommandLine(hProcess windows.Handle) (string, error) {
+	var info windows.PROCESS_BASIC_INFORMATION
+	err := windows.NtQueryInformationProcess(hProcess, windows.ProcessBasicInformation,
+		unsafe.Pointer(&info), uint32(unsafe.Sizeof(info)), nil)
+	if err != nil {
+		return "", err
+	}
+
+	var peb windows.PEB
+	err = windows.ReadProcessMemory(hProcess, uintptr(unsafe.Pointer(info.PebBaseAddress)),
+		(*byte)(unsafe.Pointer(&peb)), unsafe.Sizeof(peb), nil)
+	if err != nil {
+		return "", err
+	}
+
+	var params windows.RTL_USER_PROCESS_PARAMETERS
+	err = windows.ReadProcessMemory(hProcess, uintptr(unsafe.Pointer(peb.ProcessParameters)),
+		(*byte)(unsafe.Pointer(&params)), unsafe.Sizeof(params), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if params.CommandLine.Length == 0 {
+		return "", nil
+	}
+	commandLine := make([]uint16, params.CommandLine.Length/2)
+	err = windows.ReadProcessMemory(hProcess, uintptr(unsafe.Pointer(params.CommandLine.Buffer)),
+		(*byte)(unsafe.Pointer(&commandLine[0])), uintptr(params.CommandLine.Length), nil)
+	if err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(commandLine), nil
+}
+
+func ExtractIconExW(lpszFile *uint16, nIconIndex int32, phiconLarge *HICON, phiconSmall *HICON, nIcons uint32) uint32 {
+	ret, _, _ := procExtractIconExW.Call(
 		uintptr(unsafe.Pointer(lpszFile)),
 		uintptr(nIconIndex),
 		uintptr(unsafe.Pointer(phiconLarge)),
 		uintptr(unsafe.Pointer(phiconSmall)),
 		uintptr(nIcons),
 	)
+	for _, icon := range []*HICON{phiconLarge, phiconSmall} {
+		if icon != nil && *icon != 0 {
+			handleCounts.iconsCreated.Add(1)
+		}
+	}
+	return uint32(ret)
+}
+
+func SHGetFileInfoW(pszPath *uint16, dwFileAttributes DWORD, psfi *SHFILEINFOW, uFlags uint32) uintptr {
+	ret, _, _ := procSHGetFileInfoW.Call(
+		uintptr(unsafe.Pointer(pszPath)),
+		uintptr(dwFileAttributes),
+		uintptr(unsafe.Pointer(psfi)),
+		unsafe.Sizeof(*psfi),
+		uintptr(uFlags),
+	)
+	return ret
+}
+
+func DestroyIcon(hIcon HICON) bool {
+	ret, _, _ := procDestroyIcon.Call(uintptr(hIcon))
+	if ret != 0 {
+		handleCounts.iconsDestroyed.Add(1)
+	}
+	return ret != 0
+}
+
+// GetFileTypeIcon returns the small shell icon associated with the file type of
+// path. The file does not need to exist, only its extension is looked at.
+// The caller owns the returned icon and must free it with DestroyIcon.
+func GetFileTypeIcon(path string) (HICON, error) {
+	pathUTF16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var info SHFILEINFOW
+	ret := SHGetFileInfoW(
+		pathUTF16,
+		FILE_ATTRIBUTE_NORMAL,
+		&info,
+		SHGFI_ICON|SHGFI_SMALLICON|SHGFI_USEFILEATTRIBUTES,
+	)
+	if ret == 0 || info.HIcon == 0 {
+		return 0, fmt.Errorf("SHGetFileInfoW found no icon for %q", path)
+	}
+	handleCounts.iconsCreated.Add(1)
+	return info.HIcon, nil
+}
+
+func SHGetPropertyStoreForWindow(hwnd windows.HWND, riid *windows.GUID, ppv **IPropertyStore) error {
+	ret, _, _ := procSHGetPropertyStoreForWindow.Call(
+		uintptr(hwnd),
+		uintptr(unsafe.Pointer(riid)),
+		uintptr(unsafe.Pointer(ppv)),
+	)
+	if ret != 0 {
+		return syscall.Errno(ret)
+	}
+	return nil
+}
+
+func (ps *IPropertyStore) GetValue(key *PROPERTYKEY, pv *PROPVARIANT) error {
+	ret, _, _ := syscall.SyscallN(
+		ps.Vtbl.GetValue,
+		uintptr(unsafe.Pointer(ps)),
+		uintptr(unsafe.Pointer(key)),
+		uintptr(unsafe.Pointer(pv)),
+	)
+	if ret != 0 {
+		return syscall.Errno(ret)
+	}
+	return nil
+}
+
+func (ps *IPropertyStore) Release() uint32 {
+	ret, _, _ := syscall.SyscallN(
+		ps.Vtbl.Release,
+		uintptr(unsafe.Pointer(ps)),
+	)
 	return uint32(ret)
 }
 
-// GetEncoderClsid finds the CLSID of an image encoder by MIME type
-// mimeType examples: "image/png", "image/jpeg", "image/bmp", "image/gif"
-// Returns the CLSID and an error if the encoder is not found
-func GetEncoderClsid(mimeType string) (*windows.GUID, error) {
-	var num, size uint32
+func SHCreateItemFromParsingName(path *uint16, riid *windows.GUID, ppv **IShellItem) error {
+	ret, _, _ := procSHCreateItemFromParsingName.Call(
+		uintptr(unsafe.Pointer(path)),
+		0,
+		uintptr(unsafe.Pointer(riid)),
+		uintptr(unsafe.Pointer(ppv)),
+	)
+	if ret != 0 {
+		return syscall.Errno(ret)
+	}
+	return nil
+}
 
-	// Get the number of encoders and size of array
-	ret, _, _ := procGdipGetImageEncodersSize.Call(
-		uintptr(unsafe.Pointer(&num)),
-		uintptr(unsafe.Pointer(&size)),
+func (item *IShellItem) GetDisplayName(sigdnName uint32) (string, error) {
+	var name *uint16
+	ret, _, _ := syscall.SyscallN(
+		item.Vtbl.GetDisplayName,
+		uintptr(unsafe.Pointer(item)),
+		uintptr(sigdnName),
+		uintptr(unsafe.Pointer(&name)),
 	)
-	if ret != 0 { // GDI+ returns 0 for Ok
-		return nil, syscall.Errno(ret)
+	if ret != 0 {
+		return "", syscall.Errno(ret)
 	}
+	defer windows.CoTaskMemFree(unsafe.Pointer(name))
+	return windows.UTF16PtrToString(name), nil
+}
 
-	if size == 0 {
-		return nil, syscall.EINVAL
+func (item *IShellItem) Release() uint32 {
+	ret, _, _ := syscall.SyscallN(
+		item.Vtbl.Release,
+		uintptr(unsafe.Pointer(item)),
+	)
+	return uint32(ret)
+}
+
+// GetAppDisplayName returns the name an installed app is shown with in the
+// Start menu, looked up by its AppUserModelID in the shell's Apps folder. It
+// covers packaged apps as well as desktop apps whose shortcut sets an ID.
+func GetAppDisplayName(aumid string) (string, error) {
+	path, err := windows.UTF16PtrFromString(`shell:AppsFolder\` + aumid)
+	if err != nil {
+		return "", err
+	}
+	name := ""
+	err = withCOM(func() error {
+		var item *IShellItem
+		if err := SHCreateItemFromParsingName(path, &IID_IShellItem, &item); err != nil {
+			return fmt.Errorf("SHCreateItemFromParsingName failed: %w", err)
+		}
+		defer item.Release()
+
+		name, err = item.GetDisplayName(SIGDN_NORMALDISPLAY)
+		return err
+	})
+	return name, err
+}
+
+// GetFileDescription returns the FileDescription from the version info of
+// exePath, e.g. "Google Chrome" for chrome.exe, in the first language the
+// file lists
+func GetFileDescription(exePath string) (string, error) {
+	size, err := windows.GetFileVersionInfoSize(exePath, nil)
+	if err != nil {
+		return "", fmt.Errorf("GetFileVersionInfoSize failed: %w", err)
+	}
+	info := make([]byte, size)
+	if err := windows.GetFileVersionInfo(exePath, 0, size, unsafe.Pointer(&info[0])); err != nil {
+		return "", fmt.Errorf("GetFileVersionInfo failed: %w", err)
+	}
+
+	var translations unsafe.Pointer
+	var length uint32
+	err = windows.VerQueryValue(unsafe.Pointer(&info[0]), `\VarFileInfo\Translation`, unsafe.Pointer(&translations), &length)
+	if err != nil || length < 4 {
+		return "", fmt.Errorf("no version info translations in %s", exePath)
+	}
+	// Each translation is a language ID followed by a code page
+	translation := (*[2]uint16)(translations)
+
+	var description unsafe.Pointer
+	subBlock := fmt.Sprintf(`\StringFileInfo\%04x%04x\FileDescription`, translation[0], translation[1])
+	err = windows.VerQueryValue(unsafe.Pointer(&info[0]), subBlock, unsafe.Pointer(&description), &length)
+	if err != nil || length == 0 {
+		return "", fmt.Errorf("no FileDescription in %s", exePath)
+	}
+	return strings.TrimSpace(windows.UTF16PtrToString((*uint16)(description))), nil
+}
+
+func PropVariantClear(pv *PROPVARIANT) error {
+	ret, _, _ := procPropVariantClear.Call(uintptr(unsafe.Pointer(pv)))
+	if ret != 0 {
+		return syscall.Errno(ret)
+	}
+	return nil
+}
+
+func CoCreateInstance(rclsid *windows.GUID, dwClsContext uint32, riid *windows.GUID, ppv unsafe.Pointer) error {
+	ret, _, _ := procCoCreateInstance.Call(
+		uintptr(unsafe.Pointer(rclsid)),
+		0,
+		uintptr(dwClsContext),
+		uintptr(unsafe.Pointer(riid)),
+		uintptr(ppv),
+	)
+	if ret != 0 {
+		return syscall.Errno(ret)
+	}
+	return nil
+}
+
+func (vdm *IVirtualDesktopManager) IsWindowOnCurrentVirtualDesktop(hwnd windows.HWND) (bool, error) {
+	var onCurrent BOOL
+	ret, _, _ := syscall.SyscallN(
+		vdm.Vtbl.IsWindowOnCurrentVirtualDesktop,
+		uintptr(unsafe.Pointer(vdm)),
+		uintptr(hwnd),
+		uintptr(unsafe.Pointer(&onCurrent)),
+	)
+	if ret != 0 {
+		return false, syscall.Errno(ret)
 	}
+	return onCurrent != 0, nil
+}
+
+func (vdm *IVirtualDesktopManager) GetWindowDesktopId(hwnd windows.HWND) (windows.GUID, error) {
+	var desktopId windows.GUID
+	ret, _, _ := syscall.SyscallN(
+		vdm.Vtbl.GetWindowDesktopId,
+		uintptr(unsafe.Pointer(vdm)),
+		uintptr(hwnd),
+		uintptr(unsafe.Pointer(&desktopId)),
+	)
+	if ret != 0 {
+		return windows.GUID{}, syscall.Errno(ret)
+	}
+	return desktopId, nil
+}
+
+func (vdm *IVirtualDesktopManager) Release() uint32 {
+	ret, _, _ := syscall.SyscallN(
+		vdm.Vtbl.Release,
+		uintptr(unsafe.Pointer(vdm)),
+	)
+	return uint32(ret)
+}
+
+// withVirtualDesktopManager runs fn with an IVirtualDesktopManager. It fails
+// on systems without virtual desktops (before Windows 10).
+func withVirtualDesktopManager(fn func(vdm *IVirtualDesktopManager) error) error {
+	return withCOM(func() error {
+		var vdm *IVirtualDesktopManager
+		err := CoCreateInstance(
+			&CLSID_VirtualDesktopManager,
+			CLSCTX_ALL,
+			&IID_IVirtualDesktopManager,
+			unsafe.Pointer(&vdm),
+		)
+		if err != nil {
+			return fmt.Errorf("virtual desktops unavailable: %w", err)
+		}
+		defer vdm.Release()
+
+		return fn(vdm)
+	})
+}
+
+// VirtualDesktopsAvailable reports whether the virtual desktop API can be used
+func VirtualDesktopsAvailable() bool {
+	return withVirtualDesktopManager(func(*IVirtualDesktopManager) error { return nil }) == nil
+}
+
+// IsWindowOnCurrentDesktop reports whether hwnd is on the active virtual desktop
+func IsWindowOnCurrentDesktop(hwnd windows.HWND) (bool, error) {
+	onCurrent := true
+	err := withVirtualDesktopManager(func(vdm *IVirtualDesktopManager) error {
+		var err error
+		onCurrent, err = vdm.IsWindowOnCurrentVirtualDesktop(hwnd)
+		return err
+	})
+	return onCurrent, err
+}
+
+// WindowsOnCurrentDesktop reports for each of hwnds whether it is on the
+// active virtual desktop, sharing one IVirtualDesktopManager between them.
+// Windows that couldn't be checked are left out of the map.
+func WindowsOnCurrentDesktop(hwnds []windows.HWND) (map[windows.HWND]bool, error) {
+	onCurrent := make(map[windows.HWND]bool, len(hwnds))
+	err := withVirtualDesktopManager(func(vdm *IVirtualDesktopManager) error {
+		for _, hwnd := range hwnds {
+			if ok, err := vdm.IsWindowOnCurrentVirtualDesktop(hwnd); err == nil {
+				onCurrent[hwnd] = ok
+			}
+		}
+		return nil
+	})
+	return onCurrent, err
+}
+
+// GetWindowDesktopID returns the ID of the virtual desktop hwnd is on
+func GetWindowDesktopID(hwnd windows.HWND) (windows.GUID, error) {
+	var desktopId windows.GUID
+	err := withVirtualDesktopManager(func(vdm *IVirtualDesktopManager) error {
+		var err error
+		desktopId, err = vdm.GetWindowDesktopId(hwnd)
+		return err
+	})
+	return desktopId, err
+}
+
+// WindowDesktopIDs returns the virtual desktop of each of hwnds, sharing one
+// IVirtualDesktopManager between them. Windows that couldn't be checked are
+// left out of the map.
+func WindowDesktopIDs(hwnds []windows.HWND) (map[windows.HWND]windows.GUID, error) {
+	desktopIds := make(map[windows.HWND]windows.GUID, len(hwnds))
+	err := withVirtualDesktopManager(func(vdm *IVirtualDesktopManager) error {
+		for _, hwnd := range hwnds {
+			if id, err := vdm.GetWindowDesktopId(hwnd); err == nil {
+				desktopIds[hwnd] = id
+			}
+		}
+		return nil
+	})
+	return desktopIds, err
+}
 
-	// Allocate memory for the encoder array
-	buffer := make([]byte, size)
-	pImageCodecInfo := (*ImageCodecInfo)(unsafe.Pointer(&buffer[0]))
+// virtualDesktopsKey is where Explorer keeps the list of virtual desktops.
+// Reading it avoids the undocumented IVirtualDesktopManagerInternal, whose
+// interface IDs change between Windows builds.
+const virtualDesktopsKey = `Software\Microsoft\Windows\CurrentVersion\Explorer\VirtualDesktops`
 
-	// Get all encoder information
-	ret, _, _ = procGdipGetImageEncoders.Call(
-		uintptr(num),
-		uintptr(size),
-		uintptr(unsafe.Pointer(pImageCodecInfo)),
+// VirtualDesktopOrder returns the IDs of the virtual desktops in the order
+// Task View shows them
+func VirtualDesktopOrder() ([]windows.GUID, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, virtualDesktopsKey, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the virtual desktops key: %w", err)
+	}
+	defer key.Close()
+
+	// The IDs are stored back to back as raw GUIDs
+	data, _, err := key.GetBinaryValue("VirtualDesktopIDs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read VirtualDesktopIDs: %w", err)
+	}
+	size := int(unsafe.Sizeof(windows.GUID{}))
+	ids := make([]windows.GUID, 0, len(data)/size)
+	for i := 0; i+size <= len(data); i += size {
+		ids = append(ids, *(*windows.GUID)(unsafe.Pointer(&data[i])))
+	}
+	return ids, nil
+}
+
+// VirtualDesktopName returns the name the user gave a virtual desktop, or ""
+// for desktops that still go by their default "Desktop N" name
+func VirtualDesktopName(id windows.GUID) string {
+	key, err := registry.OpenKey(registry.CURRENT_USER, virtualDesktopsKey+`\Desktops\`+id.String(), registry.QUERY_VALUE)
+	if err != nil {
+		return ""
+	}
+	defer key.Close()
+
+	name, _, err := key.GetStringValue("Name")
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+func SysFreeString(bstr *uint16) {
+	procSysFreeString.Call(uintptr(unsafe.Pointer(bstr)))
+}
+
+func (uia *IUIAutomation) ElementFromHandle(hwnd windows.HWND) (*IUIAutomationElement, error) {
+	var element *IUIAutomationElement
+	ret, _, _ := syscall.SyscallN(
+		uia.Vtbl.ElementFromHandle,
+		uintptr(unsafe.Pointer(uia)),
+		uintptr(hwnd),
+		uintptr(unsafe.Pointer(&element)),
 	)
 	if ret != 0 {
 		return nil, syscall.Errno(ret)
 	}
+	if element == nil {
+		return nil, fmt.Errorf("no automation element for window %v", hwnd)
+	}
+	return element, nil
+}
+
+func (uia *IUIAutomation) Release() uint32 {
+	ret, _, _ := syscall.SyscallN(
+		uia.Vtbl.Release,
+		uintptr(unsafe.Pointer(uia)),
+	)
+	return uint32(ret)
+}
+
+func (e *IUIAutomationElement) CurrentName() (string, error) {
+	var bstr *uint16
+	ret, _, _ := syscall.SyscallN(
+		e.Vtbl.Get_CurrentName,
+		uintptr(unsafe.Pointer(e)),
+		uintptr(unsafe.Pointer(&bstr)),
+	)
+	if ret != 0 {
+		return "", syscall.Errno(ret)
+	}
+	if bstr == nil {
+		return "", nil
+	}
+	defer SysFreeString(bstr)
+	return windows.UTF16PtrToString(bstr), nil
+}
+
+func (e *IUIAutomationElement) Release() uint32 {
+	ret, _, _ := syscall.SyscallN(
+		e.Vtbl.Release,
+		uintptr(unsafe.Pointer(e)),
+	)
+	return uint32(ret)
+}
+
+// GetWindowAutomationName returns the UI Automation Name of hwnd's element.
+// Some windows that have no Win32 title still expose one to screen readers.
+// Creating the automation object and asking the window's provider across
+// processes is far slower than GetWindowTextW, so it's only meant as a
+// fallback for untitled windows.
+func GetWindowAutomationName(hwnd windows.HWND) (string, error) {
+	name := ""
+	err := withCOM(func() error {
+		var uia *IUIAutomation
+		err := CoCreateInstance(
+			&CLSID_CUIAutomation,
+			CLSCTX_INPROC_SERVER,
+			&IID_IUIAutomation,
+			unsafe.Pointer(&uia),
+		)
+		if err != nil {
+			return fmt.Errorf("UI Automation unavailable: %w", err)
+		}
+		defer uia.Release()
 
-	// Iterate through encoders to find matching MIME type
-	encoders := unsafe.Slice(pImageCodecInfo, num)
-	for i := uint32(0); i < num; i++ {
-		// Compare MIME types
-		if windows.UTF16PtrToString(encoders[i].MimeType) == mimeType {
-			return &encoders[i].Clsid, nil
+		element, err := uia.ElementFromHandle(hwnd)
+		if err != nil {
+			return fmt.Errorf("IUIAutomation.ElementFromHandle failed: %w", err)
 		}
+		defer element.Release()
+
+		name, err = element.CurrentName()
+		return err
+	})
+	return name, err
+}
+
+// withCOM runs fn on a locked OS thread with COM initialized
+func withCOM(fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err := windows.CoInitializeEx(0, windows.COINIT_APARTMENTTHREADED)
+	switch err {
+	case nil, syscall.Errno(windows.S_FALSE):
+		defer windows.CoUninitialize()
+	case syscall.Errno(windows.RPC_E_CHANGED_MODE):
+		// COM is already initialized on this thread in another mode
+	default:
+		return fmt.Errorf("CoInitializeEx failed: %w", err)
 	}
 
-	return nil, syscall.ENOENT
+	return fn()
+}
+
+// GetWindowAppUserModelID returns the explicit AppUserModelID of a window, or
+// an empty string if the window doesn't have one
+func GetWindowAppUserModelID(hwnd windows.HWND) (string, error) {
+	aumid := ""
+	err := withCOM(func() error {
+		var store *IPropertyStore
+		err := SHGetPropertyStoreForWindow(hwnd, &IID_IPropertyStore, &store)
+		if err != nil {
+			return fmt.Errorf("SHGetPropertyStoreForWindow failed: %w", err)
+		}
+		defer store.Release()
+
+		var value PROPVARIANT
+		err = store.GetValue(&PKEY_AppUserModel_ID, &value)
+		if err != nil {
+			return fmt.Errorf("IPropertyStore.GetValue failed: %w", err)
+		}
+		defer PropVariantClear(&value)
+
+		if value.Vt == VT_LPWSTR && value.Val[0] != 0 {
+			aumid = value.LPWSTR()
+		}
+		return nil
+	})
+	return aumid, err
+}
+
+// HICONToPNG renders icon as PNG bytes
+func HICONToPNG(icon HICON) ([]byte, error) {
+	encoded, err := EncodeIcon(icon)
+	return encoded.PNG, err
+}
+
+// EncodedIcon is an icon rendered to a PNG, along with a representative color
+// of its pixels for UI accents
+type EncodedIcon struct {
+	PNG         []byte
+	AccentColor string
 }
 
-func HICONToBase64Png(icon HICON, pngClsId *windows.GUID) (string, error) {
-	// Get icon information
+// RenderIcon draws icon into an image, with the transparency of its mask or
+// alpha channel
+func RenderIcon(icon HICON) (*image.NRGBA, error) {
 	var iconInfo ICONINFO
 	err := GetIconInfo(icon, &iconInfo)
 	if err != nil {
-		return "", fmt.Errorf("GetIconInfo failed: %w", err)
+		return nil, fmt.Errorf("GetIconInfo failed: %w", err)
+	}
+	defer DeleteBitmap(iconInfo.HbmMask)
+	if iconInfo.HbmColor != 0 {
+		defer DeleteBitmap(iconInfo.HbmColor)
 	}
 
-	// Delete mask bitmap as we don't need it
-	DeleteObject(HGDIOBJ(iconInfo.HbmMask))
-	defer DeleteObject(HGDIOBJ(iconInfo.HbmColor))
+	img, err := drawIconImage(icon, iconInfo)
+	if err != nil {
+		// Reading the icon's bitmaps directly gets most icons right too
+		drawErr := err
+		img, err = readIconImage(iconInfo)
+		if err != nil {
+			return nil, fmt.Errorf("%v when drawing the icon, %w when reading its bitmaps", drawErr, err)
+		}
+	}
+	return img, nil
+}
 
-	// Get bitmap object information
+// EncodeIcon renders icon and encodes it as a PNG with image/png, which needs
+// no GDI+
+func EncodeIcon(icon HICON) (EncodedIcon, error) {
+	img, err := RenderIcon(icon)
+	if err != nil {
+		return EncodedIcon{}, err
+	}
+
+	output := &bytes.Buffer{}
+	err = png.Encode(output, img)
+	if err != nil {
+		return EncodedIcon{}, fmt.Errorf("PNG encode failed: %w", err)
+	}
+
+	return EncodedIcon{
+		PNG:         output.Bytes(),
+		AccentColor: IconAccentColor(img),
+	}, nil
+}
+
+// iconSize returns the size of an icon from its bitmaps. Monochrome icons
+// only have a mask, which holds the AND and XOR masks on top of each other.
+func iconSize(iconInfo ICONINFO) (width, height int32, err error) {
+	hbm := iconInfo.HbmColor
+	if hbm == 0 {
+		hbm = iconInfo.HbmMask
+	}
 	var bitmap BITMAP
+	if GetObjectW(HGDIOBJ(hbm), int32(unsafe.Sizeof(bitmap)), unsafe.Pointer(&bitmap)) == 0 {
+		return 0, 0, fmt.Errorf("GetObjectW failed")
+	}
+	if iconInfo.HbmColor == 0 {
+		return int32(bitmap.BmWidth), int32(bitmap.BmHeight) / 2, nil
+	}
+	return int32(bitmap.BmWidth), int32(bitmap.BmHeight), nil
+}
+
+// drawIconImage renders icon with DrawIconEx into a 32-bit DIB, which
+// composes the color and mask bitmaps the way Windows itself displays the
+// icon. Icons without an alpha channel come out fully transparent, so their
+// opaque pixels are then taken from a second, mask-only drawing.
+func drawIconImage(icon HICON, iconInfo ICONINFO) (*image.NRGBA, error) {
+	width, height, err := iconSize(iconInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := CreateCompatibleDC(0)
+	if dc == 0 {
+		return nil, fmt.Errorf("CreateCompatibleDC failed")
+	}
+	defer DeleteDC(dc)
+
+	pixels, err := drawIconPixels(dc, icon, width, height, DI_NORMAL)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAlpha := HasAlpha(pixels)
+	if !hasAlpha {
+		mask, err := drawIconPixels(dc, icon, width, height, DI_MASK)
+		if err != nil {
+			return nil, err
+		}
+		// The mask is black where the icon is opaque
+		for i := 0; i < len(pixels); i += 4 {
+			if mask[i] == 0 {
+				pixels[i+3] = 0xFF
+			}
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
+	for i := 0; i < len(pixels); i += 4 {
+		b, g, r, a := pixels[i], pixels[i+1], pixels[i+2], pixels[i+3]
+		// DrawIconEx blends onto the zeroed DIB, leaving premultiplied colors
+		if hasAlpha && a != 0 && a != 0xFF {
+			r = byte(min(int(r)*0xFF/int(a), 0xFF))
+			g = byte(min(int(g)*0xFF/int(a), 0xFF))
+			b = byte(min(int(b)*0xFF/int(a), 0xFF))
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = r, g, b, a
+	}
+	return img, nil
+}
+
+// drawIconPixels draws icon with the given DrawIconEx flags into a new
+// top-down 32-bit DIB selected into dc, and returns a copy of its BGRA pixels
+func drawIconPixels(dc HDC, icon HICON, width, height int32, flags uint32) ([]byte, error) {
+	bitmapInfo := BITMAPINFOHEADER{
+		BiSize:        DWORD(unsafe.Sizeof(BITMAPINFOHEADER{})),
+		BiWidth:       LONG(width),
+		BiHeight:      LONG(-height), // Negative for top-down DIB
+		BiPlanes:      1,
+		BiBitCount:    32,
+		BiCompression: BI_RGB,
+	}
+	var bits unsafe.Pointer
+	hbm := CreateDIBSection(dc, &bitmapInfo, DIB_RGB_COLORS, &bits)
+	if hbm == 0 || bits == nil {
+		return nil, fmt.Errorf("CreateDIBSection failed")
+	}
+	defer DeleteBitmap(hbm)
+
+	old := SelectObject(dc, HGDIOBJ(hbm))
+	defer SelectObject(dc, old)
+
+	if err := DrawIconEx(dc, 0, 0, icon, width, height, 0, 0, flags); err != nil {
+		return nil, fmt.Errorf("DrawIconEx failed: %w", err)
+	}
+
+	return bytes.Clone(unsafe.Slice((*byte)(bits), int(width)*int(height)*4)), nil
+}
+
+// readIconImage reads the pixels of an icon's bitmaps with GetDIBits, through
+// the screen DC or else a memory DC
+func readIconImage(iconInfo ICONINFO) (*image.NRGBA, error) {
+	var img *image.NRGBA
+	var err error
+	if dc := GetDC(0); dc != 0 {
+		img, err = iconImage(dc, iconInfo)
+		ReleaseDC(0, dc)
+	} else {
+		err = fmt.Errorf("GetDC failed")
+	}
+
+	// The screen DC can be missing or unusable in session 0 and some locked
+	// or remote sessions. GetDIBits only needs a DC for palette information,
+	// which a memory DC provides without depending on the screen.
+	if err != nil {
+		screenErr := err
+		dc := CreateCompatibleDC(0)
+		if dc == 0 {
+			return nil, fmt.Errorf("%v, and CreateCompatibleDC failed", screenErr)
+		}
+		img, err = iconImage(dc, iconInfo)
+		DeleteDC(dc)
+		if err != nil {
+			return nil, fmt.Errorf("%v with the screen DC, %w with a memory DC", screenErr, err)
+		}
+	}
+	return img, nil
+}
+
+// iconImage reads the pixels of an icon's bitmaps through dc
+func iconImage(dc HDC, iconInfo ICONINFO) (*image.NRGBA, error) {
+	if iconInfo.HbmColor != 0 {
+		return colorIconImage(dc, iconInfo)
+	}
+	// Monochrome icons only have a mask bitmap
+	return monochromeIconImage(dc, iconInfo.HbmMask)
+}
+
+// getBitmapBits reads a bitmap as top-down 32-bit BGRA pixels, along with
+// the bitmap's own description. GetDIBits converts from any source format,
+// but only 32-bit sources carry alpha.
+func getBitmapBits(dc HDC, hbm HBITMAP) (buf []byte, bitmap BITMAP, err error) {
+	// Get bitmap object information
 	result := GetObjectW(
-		HGDIOBJ(iconInfo.HbmColor),
+		HGDIOBJ(hbm),
 		int32(unsafe.Sizeof(bitmap)),
 		unsafe.Pointer(&bitmap),
 	)
 	if result == 0 {
-		return "", fmt.Errorf("GetObjectW failed")
+		return nil, bitmap, fmt.Errorf("GetObjectW failed")
 	}
 
-	width := uint32(bitmap.BmWidth)
-	height := uint32(bitmap.BmHeight)
-	bufSize := int(width) * int(height) * 4
-	buf := make([]byte, bufSize)
-
-	// Get device context
-	dc := GetDC(0)
-	if dc == 0 {
-		return "", fmt.Errorf("GetDC failed")
-	}
-	defer ReleaseDC(0, dc)
+	width := int(bitmap.BmWidth)
+	height := int(bitmap.BmHeight)
+	buf = make([]byte, width*height*4)
 
 	// Setup bitmap info header
 	bitmapInfo := BITMAPINFOHEADER{
@@ -923,15 +2954,36 @@ func HICONToBase64Png(icon HICON, pngClsId *windows.GUID) (string, error) {
 	// Get DIB bits
 	result = GetDIBits(
 		dc,
-		iconInfo.HbmColor,
+		hbm,
 		0,
-		height,
+		uint32(height),
 		unsafe.Pointer(&buf[0]),
 		&bitmapInfo,
 		DIB_RGB_COLORS,
 	)
 	if result == 0 {
-		return "", fmt.Errorf("GetDIBits failed")
+		return nil, bitmap, fmt.Errorf("GetDIBits failed")
+	}
+
+	return buf, bitmap, nil
+}
+
+// colorIconImage reads a color icon. Only 32bpp color bitmaps can have an
+// alpha channel, and even those often leave it empty. The alpha GetDIBits
+// returns for the others is undefined (usually zero), so those take their
+// transparency from the mask instead.
+func colorIconImage(dc HDC, iconInfo ICONINFO) (*image.NRGBA, error) {
+	buf, bitmap, err := getBitmapBits(dc, iconInfo.HbmColor)
+	if err != nil {
+		return nil, err
+	}
+
+	if bitmap.BmBitsPixel != 32 || !HasAlpha(buf) {
+		mask, _, err := getBitmapBits(dc, iconInfo.HbmMask)
+		if err != nil {
+			return nil, fmt.Errorf("reading the mask of a %dbpp icon: %w", bitmap.BmBitsPixel, err)
+		}
+		ApplyIconMask(buf, mask)
 	}
 
 	// Swap B and R channels (BGRA to RGBA)
@@ -940,16 +2992,96 @@ func HICONToBase64Png(icon HICON, pngClsId *windows.GUID) (string, error) {
 	}
 
 	// Create RGBA image
-	img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
+	img := image.NewNRGBA(image.Rect(0, 0, int(bitmap.BmWidth), int(bitmap.BmHeight)))
 	copy(img.Pix, buf)
+	return img, nil
+}
 
-	// Encode to PNG
-	output := &bytes.Buffer{}
-	err = png.Encode(output, img)
+// HasAlpha reports whether any of the 32-bit pixels has a non-zero alpha
+func HasAlpha(pixels []byte) bool {
+	for i := 3; i < len(pixels); i += 4 {
+		if pixels[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyIconMask sets the alpha of 32-bit pixels from an icon's AND mask,
+// read as 32-bit pixels of the same size: opaque where the mask is black,
+// transparent where it's white. Pixels the mask doesn't cover are left opaque.
+func ApplyIconMask(pixels, mask []byte) {
+	for i := 0; i < len(pixels); i += 4 {
+		if i < len(mask) && mask[i] != 0 {
+			pixels[i+3] = 0
+		} else {
+			pixels[i+3] = 0xFF
+		}
+	}
+}
+
+func monochromeIconImage(dc HDC, hbmMask HBITMAP) (*image.NRGBA, error) {
+	buf, bitmap, err := getBitmapBits(dc, hbmMask)
 	if err != nil {
-		return "", fmt.Errorf("PNG encode failed: %w", err)
+		return nil, err
+	}
+	return MonochromeMaskToImage(buf, int(bitmap.BmWidth), int(bitmap.BmHeight)/2), nil
+}
+
+// MonochromeMaskToImage renders the mask of a monochrome icon as a black glyph
+// on a transparent background. mask holds 32-bit pixels of a bitmap that is
+// height*2 rows tall, with the AND mask on top and the XOR mask below it.
+func MonochromeMaskToImage(mask []byte, width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xorOffset := width * height * 4
+
+	for i := 0; i < width*height*4; i += 4 {
+		andSet := mask[i] != 0
+		xorSet := mask[xorOffset+i] != 0
+
+		// AND=1,XOR=0 is the transparent screen pixel, everything else is
+		// part of the glyph (including the "inverted screen" pixels)
+		if andSet && !xorSet {
+			continue
+		}
+		img.Pix[i+3] = 0xFF
+	}
+
+	return img
+}
+
+// DefaultAccentColor is used for icons without any opaque pixels
+const DefaultAccentColor = "#808080"
+
+// IconAccentColor picks a representative color for an icon: the average of its
+// opaque pixels, weighted by saturation so the colorful parts of the icon win
+// over white, black and grey outlines. Fully transparent icons get
+// DefaultAccentColor.
+func IconAccentColor(img *image.NRGBA) string {
+	var r, g, b, total float64
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		if img.Pix[i+3] < 128 {
+			continue
+		}
+
+		pr, pg, pb := float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])
+		high := max(pr, pg, pb)
+		low := min(pr, pg, pb)
+		saturation := 0.0
+		if high > 0 {
+			saturation = (high - low) / high
+		}
+
+		// Keep a small weight for greys so monochrome icons still get a color
+		weight := saturation + 0.05
+		r += pr * weight
+		g += pg * weight
+		b += pb * weight
+		total += weight
 	}
 
-	// Return base64 encoded PNG
-	return base64.StdEncoding.EncodeToString(output.Bytes()), nil
+	if total == 0 {
+		return DefaultAccentColor
+	}
+	return fmt.Sprintf("#%02x%02x%02x", uint8(r/total), uint8(g/total), uint8(b/total))
 }
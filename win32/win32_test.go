@@ -0,0 +1,393 @@
+package win32
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"slices"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestAltTabEligible(t *testing.T) {
+	// Whether a visible, uncloaked window with these styles is listed
+	styles := []struct {
+		exStyle     uintptr
+		isRootOwner bool
+		want        bool
+	}{
+		{0, true, true},
+		{0, false, false},
+		{WS_EX_TOOLWINDOW, true, false},
+		{WS_EX_TOOLWINDOW, false, false},
+		{WS_EX_APPWINDOW, true, true},
+		{WS_EX_APPWINDOW, false, true},
+		{WS_EX_TOOLWINDOW | WS_EX_APPWINDOW, true, true},
+		{WS_EX_TOOLWINDOW | WS_EX_APPWINDOW, false, true},
+	}
+	// Hidden and shell-cloaked windows never are, whatever their styles
+	states := []struct {
+		visible bool
+		cloaked uint32
+		listed  bool
+	}{
+		{true, 0, true},
+		{true, DWM_CLOAKED_APP, true},
+		{true, DWM_CLOAKED_SHELL, false},
+		{true, DWM_CLOAKED_INHERITED, true},
+		{false, 0, false},
+		{false, DWM_CLOAKED_APP, false},
+		{false, DWM_CLOAKED_SHELL, false},
+		{false, DWM_CLOAKED_INHERITED, false},
+	}
+
+	for _, style := range styles {
+		for _, state := range states {
+			a := AltTabAttributes{
+				Visible:     state.visible,
+				Cloaked:     state.cloaked,
+				ExStyle:     style.exStyle,
+				IsRootOwner: style.isRootOwner,
+			}
+			name := fmt.Sprintf("visible=%v,cloaked=%d,exStyle=%#x,rootOwner=%v",
+				a.Visible, a.Cloaked, a.ExStyle, a.IsRootOwner)
+			t.Run(name, func(t *testing.T) {
+				want := style.want && state.listed
+				if got := AltTabEligible(a); got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestEnumCallbackRecoversFromPanic(t *testing.T) {
+	// A nil channel pointer makes the callback panic
+	if ret := enumDesktopWindowsCallback(1, 0); ret != 1 {
+		t.Errorf("callback returned %d, want 1 to keep enumerating", ret)
+	}
+}
+
+// testBitmapSize is the width and height of the bitmaps in TestColorIconImage
+const testBitmapSize = 16
+
+// newTestDIB creates a top-down size by size DIB with bpp bits per pixel and
+// fills each of its rows with fill. Paletted bitmaps get a palette of black,
+// white and red.
+func newTestDIB(t *testing.T, bpp, size int, fill func(row []byte)) HBITMAP {
+	t.Helper()
+	return newTestBitmap(t, bpp, size, size, fill)
+}
+
+// newTestBitmap is newTestDIB for a width by height DIB
+func newTestBitmap(t *testing.T, bpp, width, height int, fill func(row []byte)) HBITMAP {
+	t.Helper()
+	info := struct {
+		header  BITMAPINFOHEADER
+		palette [256][4]byte
+	}{
+		header: BITMAPINFOHEADER{
+			BiWidth:       LONG(width),
+			BiHeight:      LONG(-height),
+			BiPlanes:      1,
+			BiBitCount:    WORD(bpp),
+			BiCompression: BI_RGB,
+		},
+	}
+	info.header.BiSize = DWORD(unsafe.Sizeof(info.header))
+	if bpp <= 8 {
+		info.header.BiClrUsed = DWORD(min(1<<bpp, 3))
+		info.palette[1] = [4]byte{0xFF, 0xFF, 0xFF, 0}
+		info.palette[2] = [4]byte{0, 0, 0xFF, 0}
+	}
+
+	var bits unsafe.Pointer
+	hbm := CreateDIBSection(0, &info.header, DIB_RGB_COLORS, &bits)
+	if hbm == 0 || bits == nil {
+		t.Fatalf("CreateDIBSection failed for %dbpp", bpp)
+	}
+	t.Cleanup(func() { DeleteBitmap(hbm) })
+
+	stride := (width*bpp + 31) / 32 * 4
+	pixels := unsafe.Slice((*byte)(bits), stride*height)
+	for y := range height {
+		fill(pixels[y*stride : (y+1)*stride])
+	}
+	return hbm
+}
+
+// newTestMask creates an AND mask that leaves the top row transparent and the
+// rest of the icon opaque
+func newTestMask(t *testing.T) HBITMAP {
+	transparent := true
+	return newTestDIB(t, 1, testBitmapSize, func(row []byte) {
+		if transparent {
+			for i := range row {
+				row[i] = 0xFF
+			}
+			transparent = false
+		}
+	})
+}
+
+func TestColorIconImage(t *testing.T) {
+	red := func(bpp int) func(row []byte) {
+		return func(row []byte) {
+			for x := range testBitmapSize {
+				switch bpp {
+				case 4:
+					// Two pixels per byte, palette entry 2
+					row[x/2] = 0x22
+				case 8:
+					row[x] = 2
+				case 24:
+					copy(row[x*3:], []byte{0, 0, 0xFF})
+				case 32:
+					copy(row[x*4:], []byte{0, 0, 0xFF, 0})
+				}
+			}
+		}
+	}
+	translucentRed := func(row []byte) {
+		for x := range testBitmapSize {
+			copy(row[x*4:], []byte{0, 0, 0xFF, 0x80})
+		}
+	}
+
+	tests := []struct {
+		name  string
+		bpp   int
+		fill  func(row []byte)
+		top   color.NRGBA
+		below color.NRGBA
+	}{
+		// Without an alpha channel, the mask makes the top row transparent
+		{"4bpp", 4, red(4), color.NRGBA{0xFF, 0, 0, 0}, color.NRGBA{0xFF, 0, 0, 0xFF}},
+		{"8bpp", 8, red(8), color.NRGBA{0xFF, 0, 0, 0}, color.NRGBA{0xFF, 0, 0, 0xFF}},
+		{"24bpp", 24, red(24), color.NRGBA{0xFF, 0, 0, 0}, color.NRGBA{0xFF, 0, 0, 0xFF}},
+		{"32bpp without alpha", 32, red(32), color.NRGBA{0xFF, 0, 0, 0}, color.NRGBA{0xFF, 0, 0, 0xFF}},
+		// A real alpha channel wins over the mask
+		{"32bpp with alpha", 32, translucentRed, color.NRGBA{0xFF, 0, 0, 0x80}, color.NRGBA{0xFF, 0, 0, 0x80}},
+	}
+
+	dc := CreateCompatibleDC(0)
+	if dc == 0 {
+		t.Fatal("CreateCompatibleDC failed")
+	}
+	defer DeleteDC(dc)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iconInfo := ICONINFO{
+				FIcon:    1,
+				HbmColor: newTestDIB(t, tt.bpp, testBitmapSize, tt.fill),
+				HbmMask:  newTestMask(t),
+			}
+			img, err := colorIconImage(dc, iconInfo)
+			if err != nil {
+				t.Fatalf("colorIconImage: %v", err)
+			}
+			if size := img.Bounds().Size(); size.X != testBitmapSize || size.Y != testBitmapSize {
+				t.Fatalf("image is %v, want %dx%[2]d", size, testBitmapSize)
+			}
+			if got := img.NRGBAAt(3, 0); got != tt.top {
+				t.Errorf("top row pixel is %v, want %v", got, tt.top)
+			}
+			if got := img.NRGBAAt(3, 5); got != tt.below {
+				t.Errorf("pixel below the top row is %v, want %v", got, tt.below)
+			}
+		})
+	}
+}
+
+func TestApplyIconMask(t *testing.T) {
+	pixels := []byte{
+		1, 2, 3, 0,
+		4, 5, 6, 0,
+		7, 8, 9, 0x80,
+	}
+	// White (transparent) for the first pixel, black for the second. The
+	// third isn't covered by the mask.
+	mask := []byte{
+		0xFF, 0xFF, 0xFF, 0,
+		0, 0, 0, 0,
+	}
+
+	if HasAlpha(pixels[:8]) {
+		t.Error("HasAlpha is true for pixels with no alpha")
+	}
+	if !HasAlpha(pixels) {
+		t.Error("HasAlpha is false for pixels with alpha")
+	}
+
+	ApplyIconMask(pixels, mask)
+	want := []byte{
+		1, 2, 3, 0,
+		4, 5, 6, 0xFF,
+		7, 8, 9, 0xFF,
+	}
+	if !slices.Equal(pixels, want) {
+		t.Errorf("masked pixels are %v, want %v", pixels, want)
+	}
+}
+
+var procCreateIconIndirect = windows.NewLazySystemDLL("user32.dll").NewProc("CreateIconIndirect")
+
+// newTestIcon creates an opaque size by size icon. It's destroyed at the end
+// of the test unless keep is set.
+func newTestIcon(t *testing.T, size int, keep bool) HICON {
+	t.Helper()
+	iconInfo := ICONINFO{
+		FIcon:    1,
+		HbmColor: newTestDIB(t, 32, size, func(row []byte) {}),
+		HbmMask:  newTestDIB(t, 1, size, func(row []byte) {}),
+	}
+	ret, _, err := procCreateIconIndirect.Call(uintptr(unsafe.Pointer(&iconInfo)))
+	if ret == 0 {
+		t.Fatalf("CreateIconIndirect failed: %v", err)
+	}
+	icon := HICON(ret)
+	if !keep {
+		t.Cleanup(func() { DestroyIcon(icon) })
+	}
+	return icon
+}
+
+func TestDegenerateIcon(t *testing.T) {
+	tests := []struct {
+		name string
+		icon HICON
+		want bool
+	}{
+		{"1x1", newTestIcon(t, 1, false), true},
+		{"7x7", newTestIcon(t, 7, false), true},
+		{"8x8", newTestIcon(t, 8, false), false},
+		{"32x32", newTestIcon(t, 32, false), false},
+		// Not known to be a placeholder
+		{"unreadable", 0, false},
+	}
+	for _, tt := range tests {
+		if got := degenerateIcon(tt.icon); got != tt.want {
+			t.Errorf("degenerateIcon(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFirstUsableIconSkipsPlaceholders(t *testing.T) {
+	placeholder := newTestIcon(t, 1, true)
+	icon := newTestIcon(t, 32, false)
+	loaded := map[string]IconInfo{
+		IconSourceBig:   {Icon: placeholder, Source: IconSourceBig, Owned: true},
+		IconSourceSmall: {Icon: icon, Source: IconSourceSmall},
+	}
+	load := func(source string) (IconInfo, bool) {
+		info, ok := loaded[source]
+		return info, ok
+	}
+
+	destroyed := GetHandleCounts().IconsDestroyed
+	info := firstUsableIcon([]string{IconSourceBig, IconSourceSmall}, load)
+	if info.Source != IconSourceSmall || info.Icon != icon {
+		t.Errorf("got the %s icon, want the %s one", info.Source, IconSourceSmall)
+	}
+	if GetHandleCounts().IconsDestroyed != destroyed+1 {
+		t.Error("the skipped placeholder wasn't destroyed")
+	}
+
+	// With nothing usable, the default application icon is used
+	info = firstUsableIcon([]string{IconSourceClass}, load)
+	if info.Source != "IDI_APPLICATION" || info.Icon == 0 {
+		t.Errorf("got the %s icon %v, want the default application icon", info.Source, info.Icon)
+	}
+}
+
+func TestMonitorWorkAreas(t *testing.T) {
+	areas, err := MonitorWorkAreas()
+	if err != nil {
+		t.Fatalf("MonitorWorkAreas: %v", err)
+	}
+	if len(areas) == 0 {
+		t.Fatal("no monitors found")
+	}
+	for _, area := range areas {
+		if area.Right <= area.Left || area.Bottom <= area.Top {
+			t.Errorf("empty work area %+v", area)
+		}
+	}
+	if n := len(monitorEnumerations.areas); n != 0 {
+		t.Errorf("%d finished enumerations left behind", n)
+	}
+}
+
+func TestMonochromeMaskToImage(t *testing.T) {
+	// A 2x2 icon, read as 32-bit pixels: the AND mask's two rows on top of
+	// the XOR mask's
+	black, white := []byte{0, 0, 0, 0}, []byte{0xFF, 0xFF, 0xFF, 0}
+	mask := slices.Concat(
+		// AND: opaque, transparent / transparent, opaque
+		black, white,
+		white, black,
+		// XOR: black, screen / inverted screen, white
+		black, black,
+		white, white,
+	)
+
+	img := MonochromeMaskToImage(mask, 2, 2)
+	glyph := color.NRGBA{A: 0xFF}
+	tests := []struct {
+		name string
+		x, y int
+		want color.NRGBA
+	}{
+		{"black", 0, 0, glyph},
+		{"transparent", 1, 0, color.NRGBA{}},
+		{"inverted", 0, 1, glyph},
+		{"white", 1, 1, glyph},
+	}
+	for _, tt := range tests {
+		if got := img.NRGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("%s pixel is %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeMonochromeIcon(t *testing.T) {
+	// An 8x8 icon whose mask holds the AND mask's rows, then the XOR mask's:
+	// two transparent rows, two inverted ones, then black
+	const size = 8
+	row := 0
+	mask := newTestBitmap(t, 1, size, size*2, func(bits []byte) {
+		if row < 4 || row == size+2 || row == size+3 {
+			for i := range bits {
+				bits[i] = 0xFF
+			}
+		}
+		row++
+	})
+	ret, _, err := procCreateIconIndirect.Call(uintptr(unsafe.Pointer(&ICONINFO{FIcon: 1, HbmMask: mask})))
+	if ret == 0 {
+		t.Fatalf("CreateIconIndirect failed: %v", err)
+	}
+	icon := HICON(ret)
+	defer DestroyIcon(icon)
+
+	encoded, err := EncodeIcon(icon)
+	if err != nil {
+		t.Fatalf("EncodeIcon: %v", err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(encoded.PNG))
+	if err != nil {
+		t.Fatalf("decoding the PNG: %v", err)
+	}
+	if got := decoded.Bounds().Size(); got.X != size || got.Y != size {
+		t.Fatalf("image is %v, want %dx%[2]d", got, size)
+	}
+	for y, want := range []uint32{0, 0, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF} {
+		if _, _, _, alpha := decoded.At(3, y).RGBA(); alpha != want {
+			t.Errorf("alpha of row %d is %#x, want %#x", y, alpha, want)
+		}
+	}
+}
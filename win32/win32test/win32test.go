@@ -0,0 +1,138 @@
+// Package win32test provides a synthetic win32.WindowProvider, so the window
+// filtering can be tested against a fixed set of windows instead of the live
+// desktop.
+package win32test
+
+import (
+	"errors"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+// FakeWindow describes a synthetic top-level window for FakeProvider
+type FakeWindow struct {
+	Hwnd      windows.HWND
+	ClassName string
+	Caption   string
+	Visible   bool
+	Iconic    bool
+	ExStyle   uintptr
+	Cloaked   uint32
+	// Owner is the owning window, 0 for unowned windows
+	Owner windows.HWND
+	// LastActivePopup defaults to the window itself when 0
+	LastActivePopup windows.HWND
+	Bounds          win32.RECT
+}
+
+// FakeProvider serves a fixed set of windows, in Z-order. Windows may be
+// listed more than once, as EnumDesktopWindows sometimes does.
+type FakeProvider struct {
+	Windows   []FakeWindow
+	Shell     windows.HWND
+	EnumError error
+}
+
+// ErrWindowNotFound is returned for windows that aren't in the provider
+var ErrWindowNotFound = errors.New("fake window not found")
+
+func (p *FakeProvider) find(hwnd windows.HWND) (FakeWindow, bool) {
+	for _, w := range p.Windows {
+		if w.Hwnd == hwnd {
+			return w, true
+		}
+	}
+	return FakeWindow{}, false
+}
+
+func (p *FakeProvider) EnumWindows() ([]windows.HWND, error) {
+	if p.EnumError != nil {
+		return nil, p.EnumError
+	}
+	hwnds := make([]windows.HWND, 0, len(p.Windows))
+	for _, w := range p.Windows {
+		hwnds = append(hwnds, w.Hwnd)
+	}
+	return hwnds, nil
+}
+
+func (p *FakeProvider) IsWindowVisible(hwnd windows.HWND) bool {
+	w, ok := p.find(hwnd)
+	return ok && w.Visible
+}
+
+func (p *FakeProvider) IsIconic(hwnd windows.HWND) bool {
+	w, ok := p.find(hwnd)
+	return ok && w.Iconic
+}
+
+func (p *FakeProvider) ClassName(hwnd windows.HWND) (string, error) {
+	w, ok := p.find(hwnd)
+	if !ok {
+		return "", ErrWindowNotFound
+	}
+	return w.ClassName, nil
+}
+
+func (p *FakeProvider) Caption(hwnd windows.HWND) (string, error) {
+	w, ok := p.find(hwnd)
+	if !ok {
+		return "", ErrWindowNotFound
+	}
+	return w.Caption, nil
+}
+
+func (p *FakeProvider) ExStyle(hwnd windows.HWND) uintptr {
+	w, _ := p.find(hwnd)
+	return w.ExStyle
+}
+
+func (p *FakeProvider) Cloaked(hwnd windows.HWND) uint32 {
+	w, _ := p.find(hwnd)
+	return w.Cloaked
+}
+
+func (p *FakeProvider) Ancestor(hwnd windows.HWND, gaFlags uint32) windows.HWND {
+	w, ok := p.find(hwnd)
+	if !ok {
+		return 0
+	}
+
+	switch gaFlags {
+	case win32.GA_PARENT:
+		// All fake windows are top-level, so their parent is the desktop
+		return 0
+	case win32.GA_ROOTOWNER:
+		for level := 0; w.Owner != 0 && level < win32.MaxLastActivePopupIterations; level++ {
+			owner, ok := p.find(w.Owner)
+			if !ok {
+				break
+			}
+			w = owner
+		}
+		return w.Hwnd
+	default:
+		return w.Hwnd
+	}
+}
+
+func (p *FakeProvider) LastActivePopup(hwnd windows.HWND) windows.HWND {
+	w, ok := p.find(hwnd)
+	if !ok || w.LastActivePopup == 0 {
+		return hwnd
+	}
+	return w.LastActivePopup
+}
+
+func (p *FakeProvider) ShellWindow() windows.HWND {
+	return p.Shell
+}
+
+func (p *FakeProvider) Bounds(hwnd windows.HWND) (win32.RECT, error) {
+	w, ok := p.find(hwnd)
+	if !ok {
+		return win32.RECT{}, ErrWindowNotFound
+	}
+	return w.Bounds, nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// SwitchModeWindows lists every window separately
+	SwitchModeWindows = "windows"
+	// SwitchModeApps lists one entry per application, like macOS Cmd+Tab
+	SwitchModeApps = "apps"
+)
+
+// appGroupKey identifies the application a window belongs to, preferring the
+// AppUserModelID that Windows itself groups taskbar buttons by
+func appGroupKey(window UserWindow) string {
+	if window.AppUserModelID != "" {
+		return "aumid:" + window.AppUserModelID
+	}
+	if window.ExePath != "" {
+		return "exe:" + strings.ToLower(window.ExePath)
+	}
+	// Nothing to group by, keep the window on its own
+	return fmt.Sprintf("hwnd:%v", window.Hwnd)
+}
+
+// appWindows returns the listed windows of the application identified by
+// appID (see UserWindow.AppID), most recently active first. It reads the
+// windows from the last enumeration pass instead of enumerating again.
+func appWindows(appID string) []UserWindow {
+	now := time.Now()
+	var windows []UserWindow
+	for _, window := range Snapshot() {
+		if appGroupKey(window) == appID && window.settled(now) {
+			window.AppID = appID
+			windows = append(windows, window)
+		}
+	}
+	sortByMRU(windows)
+	return windows
+}
+
+// duplicateKey is what windows merged by mergeDuplicates have in common
+type duplicateKey struct {
+	process    processKey
+	caption    string
+	iconSource string
+}
+
+// mergeDuplicates collapses windows of the same process with the same
+// caption and icon, which some apps create for what is really one view, into
+// their most recently active one. MergedCount is set to the number of other
+// windows merged into it, not counting the one kept. Order follows the first
+// window of each set.
+func mergeDuplicates(windows []UserWindow) []UserWindow {
+	merged := []UserWindow{}
+	index := map[duplicateKey]int{}
+
+	for _, window := range windows {
+		key := duplicateKey{window.process(), window.Caption, window.IconSource}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, window)
+			continue
+		}
+
+		count := merged[i].MergedCount + 1
+		if window.LastActive > merged[i].LastActive || window.IsForeground {
+			merged[i] = window
+		}
+		merged[i].MergedCount = count
+	}
+
+	return merged
+}
+
+// AppGroup is one application's windows, for listing them by app
+type AppGroup struct {
+	// AppID is the key the windows were grouped by (see appGroupKey)
+	AppID string
+	// Name is the app's name, taken from its executable
+	Name        string
+	WindowCount int
+	// Windows keeps the order the windows were grouped in
+	Windows []UserWindow
+}
+
+// groupApps splits windows up by application. Groups are ordered by their
+// first window.
+func groupApps(windows []UserWindow) []AppGroup {
+	groups := []AppGroup{}
+	index := map[string]int{}
+
+	for _, window := range windows {
+		key := appGroupKey(window)
+		window.AppID = key
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, AppGroup{AppID: key, Name: appName(window)})
+		}
+		groups[i].Windows = append(groups[i].Windows, window)
+		groups[i].WindowCount++
+	}
+
+	return groups
+}
+
+// groupByApp collapses windows into one entry per application, represented by
+// its most recently active window. WindowCount is set to the number of windows
+// the application has. Order follows the first window of each application.
+func groupByApp(windows []UserWindow) []UserWindow {
+	grouped := []UserWindow{}
+	for _, group := range groupApps(windows) {
+		representative := group.Windows[0]
+		for _, window := range group.Windows[1:] {
+			if window.LastActive > representative.LastActive || window.IsForeground {
+				representative = window
+			}
+		}
+		representative.WindowCount = group.WindowCount
+		grouped = append(grouped, representative)
+	}
+	return grouped
+}
+
+// appGroups returns the tracked windows grouped by application, each group's
+// windows and the groups themselves sorted by config.SortMode
+func appGroups() ([]AppGroup, error) {
+	windows, err := GetAltTabWindows()
+	return sortedAppGroups(windows), err
+}
+
+// sortedAppGroups sorts windows by config.SortMode and groups them by
+// application
+func sortedAppGroups(windows []UserWindow) []AppGroup {
+	sortWindows(windows, config.SortMode)
+	return groupApps(windows)
+}
+
+// sortByMRU orders windows from most to least recently active. Windows that
+// weren't activated while the switcher was running keep their relative Z-order.
+func sortByMRU(windows []UserWindow) {
+	slices.SortStableFunc(windows, func(a, b UserWindow) int {
+		return cmp.Compare(b.LastActive, a.LastActive)
+	})
+}
+
+const (
+	SortModeMRU          = "MRU"
+	SortModeZOrder       = "ZOrder"
+	SortModeAlphaByTitle = "AlphaByTitle"
+	SortModeAlphaByApp   = "AlphaByApp"
+)
+
+// appName is the name windows are grouped under when sorting by app
+func appName(window UserWindow) string {
+	name := filepath.Base(window.ExePath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// sortWindows orders windows, which must be in Z-order, according to one of
+// the SortMode* values. Unknown modes fall back to MRU.
+func sortWindows(windows []UserWindow, mode string) {
+	switch mode {
+	case SortModeZOrder:
+		// Already in Z-order
+	case SortModeAlphaByTitle:
+		slices.SortStableFunc(windows, func(a, b UserWindow) int {
+			return compareFold(a.Caption, b.Caption)
+		})
+	case SortModeAlphaByApp:
+		slices.SortStableFunc(windows, func(a, b UserWindow) int {
+			return cmp.Or(
+				compareFold(appName(a), appName(b)),
+				compareFold(a.Caption, b.Caption),
+			)
+		})
+	default:
+		sortByMRU(windows)
+	}
+}
+
+// compareFold compares strings case-insensitively
+func compareFold(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// GetStaleWindows returns the windows that haven't been active for longer
+// than olderThan, least recently active first. Windows not activated in this
+// session are only included with includeNeverActive, as there's no telling
+// how long they've really been left alone.
+func GetStaleWindows(olderThan time.Duration, includeNeverActive bool) ([]UserWindow, error) {
+	windows, err := GetAltTabWindows()
+	var stale []UserWindow
+	for _, window := range windows {
+		if !window.ActiveThisSession {
+			if includeNeverActive {
+				stale = append(stale, window)
+			}
+			continue
+		}
+		if time.Duration(window.LastActiveAgoMs)*time.Millisecond > olderThan {
+			stale = append(stale, window)
+		}
+	}
+	slices.SortStableFunc(stale, func(a, b UserWindow) int {
+		return cmp.Compare(a.LastActive, b.LastActive)
+	})
+	return stale, err
+}
+
+// limitWindows keeps the first max windows. The foreground window and the
+// pinned (always-on-top) windows are always kept, past the cap if need be,
+// in their place in the order. A max of 0 or less means no limit.
+func limitWindows(windows []UserWindow, max int) []UserWindow {
+	if max <= 0 || len(windows) <= max {
+		return windows
+	}
+
+	limited := windows[:max:max]
+	for _, window := range windows[max:] {
+		if window.IsForeground || window.IsTopmost {
+			limited = append(limited, window)
+		}
+	}
+	return limited
+}
+
+// windowsForMode returns the tracked windows shaped for the given switch mode
+func windowsForMode(mode string) ([]UserWindow, error) {
+	windows, err := GetAltTabWindows()
+	return shapeWindows(windows, mode), err
+}
+
+// shapeWindows filters, sorts and groups windows, in Z-order, as configured
+// for the given switch mode
+func shapeWindows(windows []UserWindow, mode string) []UserWindow {
+	for i := range windows {
+		windows[i].AppID = appGroupKey(windows[i])
+	}
+	windows = filterOcclusion(windows, config.OcclusionFilter)
+	sortWindows(windows, config.SortMode)
+	if config.MergeDuplicateWindows {
+		windows = mergeDuplicates(windows)
+	}
+	if mode == SwitchModeApps {
+		windows = groupByApp(windows)
+	}
+	windows = limitWindows(windows, config.MaxWindows)
+	if config.ShowDesktopEntry {
+		windows = append(windows, desktopEntry())
+	}
+	return windows
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+// testWindow returns a window of process pid with the given caption, last
+// active at lastActive
+func testWindow(hwnd windows.HWND, pid uint32, caption string, lastActive int) UserWindow {
+	return UserWindow{
+		Hwnd:       hwnd,
+		ProcessID:  pid,
+		Caption:    caption,
+		IconSource: "WM_GETICON",
+		LastActive: lastActive,
+	}
+}
+
+// hwndsOf returns the handles of list, in order
+func hwndsOf(list []UserWindow) []windows.HWND {
+	var hwnds []windows.HWND
+	for _, window := range list {
+		hwnds = append(hwnds, window.Hwnd)
+	}
+	return hwnds
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	list := []UserWindow{
+		testWindow(1, 10, "Inbox", 5),
+		testWindow(2, 20, "Notes", 3),
+		testWindow(3, 10, "Inbox", 9),
+		testWindow(4, 10, "Inbox", 1),
+	}
+
+	merged := mergeDuplicates(list)
+	// The set keeps the place of its first window, as its most recent one
+	if got, want := hwndsOf(merged), []windows.HWND{3, 2}; !slices.Equal(got, want) {
+		t.Fatalf("merged into %v, want %v", got, want)
+	}
+	if merged[0].MergedCount != 2 {
+		t.Errorf("MergedCount of the merged window is %d, want 2", merged[0].MergedCount)
+	}
+	if merged[1].MergedCount != 0 {
+		t.Errorf("MergedCount of the window without duplicates is %d, want 0", merged[1].MergedCount)
+	}
+}
+
+func TestMergeDuplicatesKeepsForeground(t *testing.T) {
+	foreground := testWindow(2, 10, "Inbox", 0)
+	foreground.IsForeground = true
+	merged := mergeDuplicates([]UserWindow{testWindow(1, 10, "Inbox", 5), foreground})
+
+	if len(merged) != 1 || merged[0].Hwnd != 2 || merged[0].MergedCount != 1 {
+		t.Errorf("merged into %+v, want window 2 standing for 1 other", merged)
+	}
+}
+
+func TestMergeDuplicatesNoMerge(t *testing.T) {
+	otherIcon := testWindow(4, 10, "Inbox", 0)
+	otherIcon.IconSource = "ExtractIconEx"
+	reusedPid := testWindow(5, 10, "Inbox", 0)
+	reusedPid.processStart = 2
+
+	list := []UserWindow{
+		testWindow(1, 10, "Inbox", 0),
+		// Another caption, process, icon, or process with the same PID
+		testWindow(2, 10, "Outbox", 0),
+		testWindow(3, 20, "Inbox", 0),
+		otherIcon,
+		reusedPid,
+	}
+
+	merged := mergeDuplicates(list)
+	if got, want := hwndsOf(merged), hwndsOf(list); !slices.Equal(got, want) {
+		t.Errorf("merged into %v, want %v unchanged", got, want)
+	}
+	for _, window := range merged {
+		if window.MergedCount != 0 {
+			t.Errorf("window %v has a MergedCount of %d", window.Hwnd, window.MergedCount)
+		}
+	}
+}
+
+func TestLimitWindows(t *testing.T) {
+	list := make([]UserWindow, 6)
+	for i := range list {
+		list[i].Hwnd = windows.HWND(i + 1)
+	}
+	list[3].IsTopmost = true
+	list[5].IsForeground = true
+
+	if got := hwndsOf(limitWindows(list, 0)); len(got) != len(list) {
+		t.Errorf("no limit kept %v", got)
+	}
+	if got := hwndsOf(limitWindows(list, 10)); len(got) != len(list) {
+		t.Errorf("a limit above the count kept %v", got)
+	}
+
+	// The pinned and foreground windows past the cap are kept
+	got := hwndsOf(limitWindows(list, 2))
+	if want := []windows.HWND{1, 2, 4, 6}; !slices.Equal(got, want) {
+		t.Errorf("limitWindows(list, 2) kept %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"tabswitcher/win32"
+
+	"golang.org/x/sys/windows"
+)
+
+// winMaskKey is an unassigned virtual key sent after a swallowed Win chord,
+// while Win is still held. The shell opens the Start menu when Win is pressed
+// and released with no other key in between, and it never saw the swallowed
+// key. Sending the mask on release would be too late, as it would be queued
+// behind the Win release being handled.
+const winMaskKey = 0xE8
+
+// winKeyState tracks the Windows keys from the keyboard hook's own events.
+// They come in as WM_KEYDOWN/WM_KEYUP rather than the SYSKEY messages Alt
+// chords use, and GetAsyncKeyState inside the hook lags behind the event
+// being processed. It's only used on the hook thread, so it needs no locking.
+type winKeyState struct {
+	left, right bool
+	// masked is set once winMaskKey was sent during this press
+	masked bool
+}
+
+// winKeys is the Win key state seen by the keyboard hook. It outlives the
+// hook's callbacks, so a Win key held while they're swapped is still known.
+var winKeys winKeyState
+
+func isWinKey(vk uint32) bool {
+	return vk == windows.VK_LWIN || vk == windows.VK_RWIN
+}
+
+func (s *winKeyState) held() bool {
+	return s.left || s.right
+}
+
+// track updates the state for a Win key event
+func (s *winKeyState) track(wParam win32.WPARAM, vk uint32) {
+	down := wParam == win32.WM_KEYDOWN || wParam == win32.WM_SYSKEYDOWN
+	if vk == windows.VK_LWIN {
+		s.left = down
+	} else {
+		s.right = down
+	}
+	if !s.held() {
+		s.masked = false
+	}
+}
+
+// suppress reports whether the key completing c should be swallowed, which
+// is only done for Win chords and only with config.SuppressWinHotkeys set.
+// Other chords are left for the focused app too, like before. The first Win
+// chord swallowed during a press sends winMaskKey, so releasing Win afterwards
+// doesn't open the Start menu.
+func (s *winKeyState) suppress(c chord) bool {
+	if !c.mods.Win || !config.SuppressWinHotkeys {
+		return false
+	}
+	if !s.masked {
+		s.masked = true
+		_, err := win32.SendInput([]win32.KEYBOARDINPUT{
+			{Type: win32.INPUT_KEYBOARD, Ki: win32.KEYBDINPUT{WVk: winMaskKey}},
+			{Type: win32.INPUT_KEYBOARD, Ki: win32.KEYBDINPUT{WVk: winMaskKey, DwFlags: win32.KEYEVENTF_KEYUP}},
+		})
+		if err != nil {
+			log.Printf("Failed to keep the Start menu from opening: %v", err)
+		}
+	}
+	return true
+}